@@ -0,0 +1,287 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+
+	"fiatjaf.com/nostr"
+	"github.com/aperturerobotics/go-indexeddb/idb"
+	"github.com/hack-pad/safejs"
+)
+
+// tombstone is the line of an incremental backup for a deleted or replaced event.
+type tombstone struct {
+	Deleted string `json:"deleted"`
+	Kind    int    `json:"kind"`
+	PubKey  string `json:"pubkey"`
+}
+
+// ExportSince writes, as JSON lines, the stored events saved after the
+// checkpoint and a {"deleted": id} tombstone for every event deleted or
+// replaced after it, 0 exporting everything. it returns the checkpoint of
+// this export, to pass to the next one. importing the backups in order into
+// another store ends in the same state, as long as fewer than MaxTombstones
+// events were deleted between two of them: only the latest tombstones are
+// kept.
+func (b *IndexeddbBackend) ExportSince(w io.Writer, checkpoint uint64) (uint64, error) {
+	var next uint64
+	if err := b.view(func(ctx context.Context, store *idb.ObjectStore) (err error) {
+		next, err = currentSeq(ctx, store)
+		return err
+	}); err != nil {
+		return checkpoint, err
+	}
+	if next <= checkpoint {
+		return next, nil
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, name := range []string{storeNameEvents, storeNameTombstones} {
+		after := checkpoint
+		for {
+			var lines [][]byte
+			if err := b.view(func(ctx context.Context, store *idb.ObjectStore) (err error) {
+				lines, after, err = scanSeq(ctx, store, name, after, next, exportBatch)
+				return err
+			}); err != nil {
+				return checkpoint, err
+			}
+			for _, line := range lines {
+				if _, err := bw.Write(append(line, '\n')); err != nil {
+					return checkpoint, err
+				}
+			}
+			if len(lines) < exportBatch {
+				break
+			}
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return checkpoint, err
+	}
+	return next, nil
+}
+
+// scanSeq reads, as JSON lines, up to limit records or tombstones written in
+// (after, until]. it returns the sequence to continue after.
+func scanSeq(ctx context.Context, store *idb.ObjectStore, name string, after, until uint64, limit int) ([][]byte, uint64, error) {
+	target, err := siblingStore(store, name)
+	if err != nil {
+		return nil, after, err
+	}
+	idx, err := target.Index(idxSeq)
+	if err != nil {
+		return nil, after, err
+	}
+	lower, err := safejs.ValueOf(float64(after))
+	if err != nil {
+		return nil, after, err
+	}
+	upper, err := safejs.ValueOf(float64(until))
+	if err != nil {
+		return nil, after, err
+	}
+	rb, err := idb.NewKeyRangeBound(lower, upper, true, false)
+	if err != nil {
+		return nil, after, err
+	}
+	req, err := idx.OpenCursorRange(rb, idb.CursorNext)
+	if err != nil {
+		return nil, after, err
+	}
+
+	lines := [][]byte{}
+	err = req.Iter(ctx, func(cursor *idb.CursorWithValue) error {
+		id, err := cursor.PrimaryKey()
+		if err != nil {
+			return err
+		}
+		value, err := cursor.Value()
+		if err != nil {
+			return err
+		}
+		var line []byte
+		if name == storeNameTombstones {
+			line, err = valueToTombstone(id, value)
+		} else {
+			var evt nostr.Event
+			if evt, err = valueToEvent(id, value); err == nil {
+				line, err = json.Marshal(evt)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		seq_, err := value.Get(keySeq)
+		if err != nil {
+			return err
+		}
+		seq, err := seq_.Float()
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line)
+		after = uint64(seq)
+		if len(lines) == limit {
+			return idb.ErrCursorStopIter
+		}
+		return nil
+	})
+	return lines, after, err
+}
+
+// putTombstone records the deletion of an event with the next write sequence.
+func putTombstone(ctx context.Context, store *idb.ObjectStore, evt nostr.Event) error {
+	seq, err := nextSeq(ctx, store)
+	if err != nil {
+		return err
+	}
+	tombstones, err := siblingStore(store, storeNameTombstones)
+	if err != nil {
+		return err
+	}
+	rawID, err := safejs.ValueOf(evt.ID.Hex())
+	if err != nil {
+		return err
+	}
+	rawObj, err := safejs.ValueOf(map[string]any{
+		keyKind:   evt.Kind.Num(),
+		keyAuthor: evt.PubKey.Hex(),
+		keySeq:    seq,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tombstones.PutKey(rawID, rawObj)
+	return err
+}
+
+// leavesTombstones tells if a change deleted or replaced events.
+func leavesTombstones(change Change) bool {
+	return change.Op == ChangeDelete || len(change.Replaced) > 0
+}
+
+// pruneTombstones drops the oldest tombstones past max.
+func pruneTombstones(ctx context.Context, store *idb.ObjectStore, max int) error {
+	tombstones, err := siblingStore(store, storeNameTombstones)
+	if err != nil {
+		return err
+	}
+	countReq, err := tombstones.Count()
+	if err != nil {
+		return err
+	}
+	count, err := countReq.Await(ctx)
+	if err != nil {
+		return err
+	}
+	excess := int(count) - max
+	if excess <= 0 {
+		return nil
+	}
+	idx, err := tombstones.Index(idxSeq)
+	if err != nil {
+		return err
+	}
+	req, err := idx.OpenCursor(idb.CursorNext)
+	if err != nil {
+		return err
+	}
+	return req.Iter(ctx, func(cursor *idb.CursorWithValue) error {
+		if excess <= 0 {
+			return idb.ErrCursorStopIter
+		}
+		excess--
+		_, err := cursor.Delete()
+		return err
+	})
+}
+
+func valueToTombstone(rawID, rawTombstone safejs.Value) ([]byte, error) {
+	id, err := rawID.String()
+	if err != nil {
+		return nil, err
+	}
+	k_, err := rawTombstone.Get(keyKind)
+	if err != nil {
+		return nil, err
+	}
+	k, err := k_.Int()
+	if err != nil {
+		return nil, err
+	}
+	a_, err := rawTombstone.Get(keyAuthor)
+	if err != nil {
+		return nil, err
+	}
+	a, err := a_.String()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(tombstone{Deleted: id, Kind: k, PubKey: a})
+}
+
+// nextSeq increments the write sequence of the store and returns it.
+func nextSeq(ctx context.Context, store *idb.ObjectStore) (float64, error) {
+	seq, err := currentSeq(ctx, store)
+	if err != nil {
+		return 0, err
+	}
+	state, err := siblingStore(store, storeNameState)
+	if err != nil {
+		return 0, err
+	}
+	key, err := safejs.ValueOf(stateKeySeq)
+	if err != nil {
+		return 0, err
+	}
+	value, err := safejs.ValueOf(float64(seq + 1))
+	if err != nil {
+		return 0, err
+	}
+	if _, err := state.PutKey(key, value); err != nil {
+		return 0, err
+	}
+	return float64(seq + 1), nil
+}
+
+// currentSeq reads the sequence of the last write.
+func currentSeq(ctx context.Context, store *idb.ObjectStore) (uint64, error) {
+	state, err := siblingStore(store, storeNameState)
+	if err != nil {
+		return 0, err
+	}
+	key, err := safejs.ValueOf(stateKeySeq)
+	if err != nil {
+		return 0, err
+	}
+	req, err := state.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	value, err := req.Await(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if value.IsUndefined() || value.IsNull() {
+		return 0, nil
+	}
+	seq, err := value.Float()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(seq), nil
+}
+
+// siblingStore returns another store of the transaction of a store.
+func siblingStore(store *idb.ObjectStore, name string) (*idb.ObjectStore, error) {
+	tx, err := store.Transaction()
+	if err != nil {
+		return nil, err
+	}
+	return tx.ObjectStore(name)
+}
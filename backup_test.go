@@ -0,0 +1,146 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"fiatjaf.com/nostr"
+)
+
+func TestExportSince(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob := nostr.Generate(), nostr.Generate()
+	follows, err := db.saveSigned(alice, nostr.KindFollowList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutes, err := db.saveSigned(bob, nostr.KindMuteList)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var first bytes.Buffer
+	checkpoint, err := db.ExportSince(&first, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(first.String(), "\n"); n != 2 {
+		t.Fatalf("expected: 2 lines, actual: %d", n)
+	}
+
+	newer := nostr.Event{Kind: nostr.KindFollowList, CreatedAt: follows.CreatedAt + 1, Tags: nostr.Tags{}}
+	if err := newer.Sign(alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ReplaceEvent(newer); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteEvent(mutes.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.saveSigned(bob, nostr.KindRelayListMetadata); err != nil {
+		t.Fatal(err)
+	}
+
+	var second bytes.Buffer
+	checkpoint, err = db.ExportSince(&second, checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2 saved events and the tombstones of the replaced and deleted ones
+	if n := strings.Count(second.String(), "\n"); n != 4 {
+		t.Fatalf("expected: 4 lines, actual: %d\n%s", n, second.String())
+	}
+	if !strings.Contains(second.String(), `{"deleted":"`+mutes.ID.Hex()+`"`) {
+		t.Fatalf("tombstone of %s not found in:\n%s", mutes.ID, second.String())
+	}
+
+	var third bytes.Buffer
+	if next, err := db.ExportSince(&third, checkpoint); err != nil || next != checkpoint || third.Len() != 0 {
+		t.Fatalf("expected nothing after the last checkpoint, actual: %d, %v, %q", next, err, third.String())
+	}
+
+	var expected bytes.Buffer
+	if _, err := db.Export(&expected, nostr.Filter{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Import(&first); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := db.Import(&second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Deleted != 1 || summary.Saved != 2 || summary.Replaced != 1 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+	var actual bytes.Buffer
+	if _, err := db.Export(&actual, nostr.Filter{}); err != nil {
+		t.Fatal(err)
+	}
+	if actual.String() != expected.String() {
+		t.Fatalf("expected: %s, actual: %s", expected.String(), actual.String())
+	}
+}
+
+func TestImportTombstone(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	evt, err := db.saveSigned(nostr.Generate(), nostr.KindFollowList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the fields of a tombstone may come in any order
+	line := `{"kind":3,"pubkey":"` + evt.PubKey.Hex() + `","deleted":"` + evt.ID.Hex() + `"}` + "\n"
+	summary, err := db.Import(strings.NewReader(line + `{"deleted":"nothex"}` + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Deleted != 1 || summary.Invalid != 1 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+	if db.IsExisted(db.ctx, evt.ID.Hex()) {
+		t.Fatal("event not deleted by its tombstone")
+	}
+}
+
+func TestPruneTombstones(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.MaxTombstones = 1
+	ids := []nostr.ID{}
+	for range 2 {
+		evt, err := db.saveSigned(nostr.Generate(), nostr.KindFollowList)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, evt.ID)
+	}
+	for _, id := range ids {
+		if err := db.DeleteEvent(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := db.ExportSince(&buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	// only the latest deletion is kept
+	if strings.Contains(buf.String(), ids[0].Hex()) || !strings.Contains(buf.String(), ids[1].Hex()) {
+		t.Fatalf("unexpected tombstones: %s", buf.String())
+	}
+}
@@ -0,0 +1,273 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"syscall/js"
+
+	"fiatjaf.com/nostr"
+)
+
+// ExposeJS registers a global object of the name for the code not written in
+// Go, backed by the backend. its methods take NIP-01 filters and events, as
+// objects or JSON strings:
+//
+//	query(filter, limit?)  async iterable of the matching events
+//	save(event)            Promise
+//	replace(event)         Promise
+//	delete(id)             Promise, id being hex
+//	count(filter)          Promise of the number of matching events
+//	subscribe(filter, cb)  calls cb with every {op, event, replaced, remote}
+//	                       change like Subscribe, returns the unsubscribe function
+//
+// bad arguments reject the Promise, or are logged by subscribe, like what the
+// subscribe callbacks throw. the iterators read a few events ahead, and stop
+// once done or returned. the unsubscribe functions are released once done and
+// must not be called after that. it returns a function that removes the
+// global object.
+func ExposeJS(b *IndexeddbBackend, name string) func() {
+	funcs := []js.Func{
+		js.FuncOf(func(this js.Value, args []js.Value) any {
+			var filter nostr.Filter
+			if err := fromJS(arg(args, 0), &filter); err != nil {
+				return rejected(err)
+			}
+			limit := 0
+			if l := arg(args, 1); l.Type() == js.TypeNumber {
+				limit = l.Int()
+			}
+			return queryIterable(b, filter, limit)
+		}),
+		js.FuncOf(func(this js.Value, args []js.Value) any {
+			var evt nostr.Event
+			if err := fromJS(arg(args, 0), &evt); err != nil {
+				return rejected(err)
+			}
+			return promise(func() (any, error) { return nil, b.SaveEvent(evt) })
+		}),
+		js.FuncOf(func(this js.Value, args []js.Value) any {
+			var evt nostr.Event
+			if err := fromJS(arg(args, 0), &evt); err != nil {
+				return rejected(err)
+			}
+			return promise(func() (any, error) { return nil, b.ReplaceEvent(evt) })
+		}),
+		js.FuncOf(func(this js.Value, args []js.Value) any {
+			if arg(args, 0).Type() != js.TypeString {
+				return rejected(fmt.Errorf("delete expects a hex id"))
+			}
+			id, err := nostr.IDFromHex(args[0].String())
+			if err != nil {
+				return rejected(err)
+			}
+			return promise(func() (any, error) { return nil, b.DeleteEvent(id) })
+		}),
+		js.FuncOf(func(this js.Value, args []js.Value) any {
+			var filter nostr.Filter
+			if err := fromJS(arg(args, 0), &filter); err != nil {
+				return rejected(err)
+			}
+			return promise(func() (any, error) {
+				count, err := b.CountEvents(filter)
+				return int(count), err
+			})
+		}),
+		js.FuncOf(func(this js.Value, args []js.Value) any {
+			// a panic would end the program, so bad arguments are only logged
+			var filter nostr.Filter
+			if err := fromJS(arg(args, 0), &filter); err != nil {
+				logErr(err)
+				return js.Undefined()
+			}
+			cb := arg(args, 1)
+			if cb.Type() != js.TypeFunction {
+				logErr(fmt.Errorf("subscribe expects a callback"))
+				return js.Undefined()
+			}
+			return subscribeJS(b, filter, cb)
+		}),
+	}
+	obj := js.Global().Get("Object").New()
+	for i, method := range []string{"query", "save", "replace", "delete", "count", "subscribe"} {
+		obj.Set(method, funcs[i])
+	}
+	js.Global().Set(name, obj)
+	return func() {
+		js.Global().Delete(name)
+		for _, f := range funcs {
+			f.Release()
+		}
+	}
+}
+
+// iterableWindow is how many events a query iterator reads ahead of js.
+const iterableWindow = 16
+
+// finished stands for the next and return methods of the iterators once they
+// are done, their own functions being released.
+var finished = js.FuncOf(func(this js.Value, args []js.Value) any {
+	return js.Global().Get("Promise").Call("resolve", iteratorResult(js.Undefined(), true))
+})
+
+// asyncIterator is the [Symbol.asyncIterator] method of the iterators.
+var asyncIterator = js.FuncOf(func(this js.Value, args []js.Value) any {
+	return this
+})
+
+// queryIterable runs a query in the background, its events being taken by the
+// async iterator. the ids are read first, then the events in batches of
+// iterableWindow, each in its own transaction, as js takes them.
+func queryIterable(b *IndexeddbBackend, filter nostr.Filter, limit int) js.Value {
+	events := make(chan nostr.Event, iterableWindow)
+	canceled := make(chan struct{})
+	go func() {
+		defer close(events)
+		ids := b.queryIDs(filter, limit)
+		for len(ids) > 0 {
+			n := min(len(ids), iterableWindow)
+			batch, err := b.getEvents(ids[:n])
+			if err != nil {
+				logErr(err)
+				return
+			}
+			ids = ids[n:]
+			for _, evt := range batch {
+				select {
+				case events <- evt:
+				case <-canceled:
+					return
+				}
+			}
+		}
+	}()
+
+	iterator := js.Global().Get("Object").New()
+	var next, stop js.Func
+	var finish sync.Once
+	done := func() {
+		finish.Do(func() {
+			close(canceled)
+			iterator.Set("next", finished)
+			iterator.Set("return", finished)
+			// the functions are released once js is done calling them
+			go func() {
+				next.Release()
+				stop.Release()
+			}()
+		})
+	}
+	next = js.FuncOf(func(this js.Value, args []js.Value) any {
+		return promise(func() (any, error) {
+			evt, ok := <-events
+			if !ok {
+				done()
+				return iteratorResult(js.Undefined(), true), nil
+			}
+			return iteratorResult(toJS(evt), false), nil
+		})
+	})
+	stop = js.FuncOf(func(this js.Value, args []js.Value) any {
+		done()
+		return finished.Invoke()
+	})
+	iterator.Set("next", next)
+	iterator.Set("return", stop)
+	js.Global().Get("Reflect").Call("set", iterator, js.Global().Get("Symbol").Get("asyncIterator"), asyncIterator)
+	return iterator
+}
+
+// subscribeJS calls cb with the changes of a subscription and returns the js
+// function that cancels it.
+func subscribeJS(b *IndexeddbBackend, filter nostr.Filter, cb js.Value) js.Func {
+	changes, cancel := b.Subscribe(filter)
+	var unsubscribe js.Func
+	unsubscribe = js.FuncOf(func(this js.Value, args []js.Value) any {
+		cancel()
+		return nil
+	})
+	go func() {
+		for change := range changes {
+			c := js.Global().Get("Object").New()
+			c.Set("op", change.Op.String())
+			if change.Op != ChangeEOSE {
+				c.Set("event", toJS(change.Event))
+			}
+			replaced := js.Global().Get("Array").New()
+			for _, evt := range change.Replaced {
+				replaced.Call("push", toJS(evt))
+			}
+			c.Set("replaced", replaced)
+			c.Set("remote", change.Remote)
+			invoke(cb, c)
+		}
+		cancel()
+		unsubscribe.Release()
+	}()
+	return unsubscribe
+}
+
+// promise runs fn in the background, js callbacks must not block, and settles
+// the returned Promise with its result.
+func promise(fn func() (any, error)) js.Value {
+	executor := js.FuncOf(func(this js.Value, args []js.Value) any {
+		resolve, reject := args[0], args[1]
+		go func() {
+			value, err := fn()
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+				return
+			}
+			resolve.Invoke(value)
+		}()
+		return nil
+	})
+	defer executor.Release()
+	return js.Global().Get("Promise").New(executor)
+}
+
+// invoke calls a js function, logging what it throws instead of panicking.
+func invoke(fn js.Value, args ...any) {
+	defer func() {
+		if r := recover(); r != nil {
+			logErr(fmt.Errorf("js callback threw: %v", r))
+		}
+	}()
+	fn.Invoke(args...)
+}
+
+func rejected(err error) js.Value {
+	return js.Global().Get("Promise").Call("reject", js.Global().Get("Error").New(err.Error()))
+}
+
+func iteratorResult(value js.Value, done bool) js.Value {
+	result := js.Global().Get("Object").New()
+	result.Set("value", value)
+	result.Set("done", done)
+	return result
+}
+
+// fromJS decodes a NIP-01 object, or its JSON string.
+func fromJS(value js.Value, v any) error {
+	switch value.Type() {
+	case js.TypeString:
+		return json.Unmarshal([]byte(value.String()), v)
+	case js.TypeObject:
+		return json.Unmarshal([]byte(js.Global().Get("JSON").Call("stringify", value).String()), v)
+	}
+	return fmt.Errorf("expected an object or a JSON string, got %s", value.Type())
+}
+
+// toJS turns an event into its NIP-01 object.
+func toJS(evt nostr.Event) js.Value {
+	return js.Global().Get("JSON").Call("parse", evt.String())
+}
+
+func arg(args []js.Value, i int) js.Value {
+	if i < len(args) {
+		return args[i]
+	}
+	return js.Undefined()
+}
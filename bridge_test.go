@@ -0,0 +1,200 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"syscall/js"
+	"testing"
+	"time"
+
+	"fiatjaf.com/nostr"
+)
+
+func TestBridge(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remove := ExposeJS(db.IndexeddbBackend, "eventstoreTest")
+	defer remove()
+	store := js.Global().Get("eventstoreTest")
+
+	changes := make(chan js.Value, 10)
+	onChange := js.FuncOf(func(this js.Value, args []js.Value) any {
+		changes <- args[0]
+		return nil
+	})
+	defer onChange.Release()
+	unsubscribe := store.Call("subscribe", `{"kinds":[3]}`, onChange)
+	if op := (<-changes).Get("op").String(); op != "eose" {
+		t.Fatalf("expected: eose, actual: %s", op)
+	}
+
+	sk := nostr.Generate()
+	evt := nostr.Event{Kind: nostr.KindFollowList, CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	if err := evt.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	// events are taken as objects too
+	if _, err := await(store.Call("replace", js.Global().Get("JSON").Call("parse", evt.String()))); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case change := <-changes:
+		if change.Get("op").String() != "save" || change.Get("event").Get("id").String() != evt.ID.Hex() {
+			t.Fatalf("unexpected change: %s", js.Global().Get("JSON").Call("stringify", change))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no change")
+	}
+	unsubscribe.Invoke()
+
+	iterator := store.Call("query", js.ValueOf(map[string]any{"kinds": []any{3}, "authors": []any{sk.Public().Hex()}})).
+		Call("next")
+	result, err := await(iterator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Get("done").Bool() || result.Get("value").Get("sig").String() != hex.EncodeToString(evt.Sig[:]) {
+		t.Fatalf("unexpected result: %s", js.Global().Get("JSON").Call("stringify", result))
+	}
+
+	if _, err := await(store.Call("delete", evt.ID.Hex())); err != nil {
+		t.Fatal(err)
+	}
+	if db.IsExisted(db.ctx, evt.ID.Hex()) {
+		t.Fatal("expected the event to be deleted")
+	}
+	if _, err := await(store.Call("delete", "not an id")); err == nil {
+		t.Fatal("expected a bad id to be rejected")
+	}
+}
+
+func TestBridgeQuery(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remove := ExposeJS(db.IndexeddbBackend, "eventstoreTest")
+	defer remove()
+	store := js.Global().Get("eventstoreTest")
+
+	// more events than the iterator reads ahead
+	sk := nostr.Generate()
+	n := iterableWindow*2 + 1
+	for i := range n {
+		evt := nostr.Event{Kind: nostr.KindCategorizedPeopleList, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"d", strconv.Itoa(i)}}}
+		if err := evt.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := await(store.Call("save", evt.String())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	filter := `{"kinds":[30000],"authors":["` + sk.Public().Hex() + `"]}`
+	count, err := await(store.Call("count", filter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count.Int() != n {
+		t.Fatalf("expected: %d counted, actual: %d", n, count.Int())
+	}
+	if _, err := await(store.Call("count", "not json")); err == nil {
+		t.Fatal("expected a bad filter to be rejected")
+	}
+
+	iterator := store.Call("query", filter)
+	ids := map[string]bool{}
+	for {
+		result, err := await(iterator.Call("next"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Get("done").Bool() {
+			break
+		}
+		ids[result.Get("value").Get("id").String()] = true
+	}
+	if len(ids) != n {
+		t.Fatalf("expected: %d events, actual: %d", n, len(ids))
+	}
+	// a done iterator stays done
+	result, err := await(iterator.Call("next"))
+	if err != nil || !result.Get("done").Bool() {
+		t.Fatalf("expected done, actual: %v, %v", result, err)
+	}
+
+	// returning early stops the iterator
+	iterator = store.Call("query", filter)
+	if _, err := await(iterator.Call("next")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := await(iterator.Call("return")); err != nil {
+		t.Fatal(err)
+	}
+	result, err = await(iterator.Call("next"))
+	if err != nil || !result.Get("done").Bool() {
+		t.Fatalf("expected done after return, actual: %v, %v", result, err)
+	}
+}
+
+func TestBridgeThrowingCallback(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remove := ExposeJS(db.IndexeddbBackend, "eventstoreTest")
+	defer remove()
+	store := js.Global().Get("eventstoreTest")
+
+	calls := make(chan string, 10)
+	onChange := js.FuncOf(func(this js.Value, args []js.Value) any {
+		calls <- args[0].Get("op").String()
+		return nil
+	})
+	defer onChange.Release()
+	// a callback that throws after each call keeps being called
+	throwing := js.Global().Get("Function").New("cb", "return (c) => { cb(c); throw new Error('oops') }").Invoke(onChange)
+	unsubscribe := store.Call("subscribe", `{"kinds":[3]}`, throwing)
+	defer unsubscribe.Invoke()
+	if op := <-calls; op != "eose" {
+		t.Fatalf("expected: eose, actual: %s", op)
+	}
+	if _, err := db.saveSigned(nostr.Generate(), nostr.KindFollowList); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case op := <-calls:
+		if op != "save" {
+			t.Fatalf("expected: save, actual: %s", op)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no change after the callback threw")
+	}
+}
+
+// await waits for a Promise to settle.
+func await(p js.Value) (js.Value, error) {
+	values := make(chan js.Value, 1)
+	errs := make(chan error, 1)
+	then := js.FuncOf(func(this js.Value, args []js.Value) any {
+		values <- args[0]
+		return nil
+	})
+	defer then.Release()
+	catch := js.FuncOf(func(this js.Value, args []js.Value) any {
+		errs <- errors.New(args[0].Get("message").String())
+		return nil
+	})
+	defer catch.Release()
+	p.Call("then", then, catch)
+	select {
+	case v := <-values:
+		return v, nil
+	case err := <-errs:
+		return js.Undefined(), err
+	}
+}
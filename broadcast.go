@@ -0,0 +1,140 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"context"
+	"encoding/json"
+	"syscall/js"
+
+	"fiatjaf.com/nostr"
+	"github.com/aperturerobotics/go-indexeddb/idb"
+)
+
+// notice is the compact form of a change sent to the other tabs.
+type notice struct {
+	Op       string   `json:"op"`
+	ID       string   `json:"id"`
+	Kind     int      `json:"kind"`
+	PubKey   string   `json:"pubkey"`
+	Replaced []string `json:"replaced,omitempty"`
+}
+
+// channel is the BroadcastChannel shared by the backends of every tab on the
+// same database.
+type channel struct {
+	value     js.Value
+	onMessage js.Func
+}
+
+// openChannel starts listening to the changes made by the other tabs, when
+// the browser has BroadcastChannel.
+func (b *IndexeddbBackend) openChannel() {
+	if b.channel != nil {
+		return
+	}
+	ctor := js.Global().Get("BroadcastChannel")
+	if ctor.IsUndefined() {
+		logWarn("BroadcastChannel is not supported, changes of other tabs won't be seen")
+		return
+	}
+	c := &channel{value: ctor.New(databaseName)}
+	c.onMessage = js.FuncOf(func(this js.Value, args []js.Value) any {
+		data := args[0].Get("data")
+		if data.Type() != js.TypeString {
+			return nil
+		}
+		var n notice
+		if err := json.Unmarshal([]byte(data.String()), &n); err != nil {
+			logErr(err)
+			return nil
+		}
+		// reading the store blocks, which a js callback must not
+		go b.received(n)
+		return nil
+	})
+	c.value.Call("addEventListener", "message", c.onMessage)
+	b.channel = c
+}
+
+func (b *IndexeddbBackend) closeChannel() {
+	if b.channel == nil {
+		return
+	}
+	b.channel.value.Call("removeEventListener", "message", b.channel.onMessage)
+	b.channel.value.Call("close")
+	b.channel.onMessage.Release()
+	b.channel = nil
+}
+
+// broadcast tells the other tabs about a change committed here.
+func (b *IndexeddbBackend) broadcast(change Change) {
+	if b.channel == nil {
+		return
+	}
+	n := notice{
+		Op:     change.Op.String(),
+		ID:     change.Event.ID.Hex(),
+		Kind:   int(change.Event.Kind),
+		PubKey: change.Event.PubKey.Hex(),
+	}
+	for _, evt := range change.Replaced {
+		n.Replaced = append(n.Replaced, evt.ID.Hex())
+	}
+	data, err := json.Marshal(n)
+	if err != nil {
+		logErr(err)
+		return
+	}
+	b.channel.value.Call("postMessage", string(data))
+}
+
+// received turns a notice of another tab into a local change. saved events are
+// read back from the store, deleted and replaced ones only have their id, kind
+// and pubkey.
+func (b *IndexeddbBackend) received(n notice) {
+	change := Change{Remote: true}
+	for op := ChangeSave; op <= ChangeDelete; op++ {
+		if op.String() == n.Op {
+			change.Op = op
+		}
+	}
+	if change.Op == ChangeStored {
+		logWarn("unknown change notice: " + n.Op)
+		return
+	}
+	id, err := nostr.IDFromHex(n.ID)
+	if err != nil {
+		logErr(err)
+		return
+	}
+	pubkey, err := nostr.PubKeyFromHex(n.PubKey)
+	if err != nil {
+		logErr(err)
+		return
+	}
+	kind := nostr.Kind(n.Kind)
+
+	if change.Op == ChangeDelete {
+		change.Event = nostr.Event{ID: id, Kind: kind, PubKey: pubkey}
+	} else {
+		var found bool
+		if err := b.view(func(ctx context.Context, store *idb.ObjectStore) (err error) {
+			change.Event, found, err = getEvent(ctx, store, id)
+			return err
+		}); err != nil {
+			logErr(err)
+			return
+		}
+		if !found {
+			// already replaced or deleted, another notice follows
+			return
+		}
+	}
+	for _, replaced := range n.Replaced {
+		if id, err := nostr.IDFromHex(replaced); err == nil {
+			change.Replaced = append(change.Replaced, nostr.Event{ID: id, Kind: kind, PubKey: pubkey})
+		}
+	}
+	b.apply(change)
+}
@@ -0,0 +1,47 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"testing"
+
+	"fiatjaf.com/nostr"
+)
+
+func TestBroadcast(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another tab on the same database
+	other := &IndexeddbBackend{}
+	if err := other.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	changes, cancel := db.Subscribe(nostr.Filter{Kinds: []nostr.Kind{nostr.KindFollowList}})
+	defer cancel()
+	receive(t, changes, ChangeEOSE)
+
+	sk := nostr.Generate()
+	evt := nostr.Event{Kind: nostr.KindFollowList, CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	if err := evt.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.SaveEvent(evt); err != nil {
+		t.Fatal(err)
+	}
+	change := receive(t, changes, ChangeSave)
+	if !change.Remote || change.Event.ID != evt.ID || change.Event.Sig != evt.Sig {
+		t.Fatalf("unexpected remote save: %+v", change)
+	}
+
+	if err := other.DeleteEvent(evt.ID); err != nil {
+		t.Fatal(err)
+	}
+	change = receive(t, changes, ChangeDelete)
+	if !change.Remote || change.Event.ID != evt.ID || change.Event.PubKey != sk.Public() {
+		t.Fatalf("unexpected remote delete: %+v", change)
+	}
+}
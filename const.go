@@ -2,13 +2,26 @@
 
 package indexeddb
 
+import "time"
+
 const (
-	databaseVersion = 4
+	databaseVersion = 18
+
+	defaultBlockedTimeout = 10 * time.Second
+	defaultMaxTombstones  = 10000
+	defaultSeenRetention  = 90 * 24 * time.Hour
 )
 
 const (
-	databaseName    = "eventstore"
-	storeNameEvents = "events"
+	databaseName        = "eventstore"
+	storeNameEvents     = "events"
+	storeNameTombstones = "tombstones"
+	storeNameState      = "state"
+	storeNameOplog      = "oplog"
+	storeNameSeen       = "seen"
+	storeNameMissing    = "missing"
+
+	stateKeySeq = "seq"
 
 	keyKind               = "k"
 	keyAuthor             = "a"
@@ -18,8 +31,28 @@ const (
 	keySignature          = "s"
 	keyKindTagAuthorArray = "kta"
 	keyMeta               = "m"
+	keySearchTerms        = "st"
+	keyRelayCaps          = "rc"
+	keySeq                = "sq"
+	keyOp                 = "op"
+	keyID                 = "id"
+	keyAt                 = "at"
+	keyRemoved            = "rm"
+	keyRelay              = "r"
+	keyFirstSeen          = "fs"
+	keyLastSeen           = "ls"
+	keyFetchedAt          = "fa"
+	keyExpires            = "ex"
 
 	idxKindAuthor    = "xka"
 	idxKindMeta      = "xkm"
 	idxKindTagAuthor = "xkta"
+	idxSearchTerms   = "xst"
+	idxRelayCaps     = "xrc"
+	idxSeq           = "xsq"
+	idxCreatedAt     = "xca"
+	idxAuthorRelay   = "xar"
+	idxKindFetched   = "xkf"
+	idxExpires       = "xex"
+	idxLastSeen      = "xls"
 )
@@ -3,10 +3,184 @@
 package indexeddb
 
 import (
+	"context"
+	"strconv"
+	"strings"
+
 	"fiatjaf.com/nostr"
+	"github.com/aperturerobotics/go-indexeddb/idb"
+	"github.com/hack-pad/safejs"
 )
 
-func (b *IndexeddbBackend) CountEvents(nostr.Filter) (uint32, error) {
-	// TODO:
-	return 0, nil
+// CountEvents counts the stored events matching the filter, regardless of its
+// limit, with the index ranges QueryEvents reads. searches, which are ranked
+// rather than ranged, are counted by reading them.
+func (b *IndexeddbBackend) CountEvents(filter nostr.Filter) (uint32, error) {
+	if err := validateFilter(filter); err != nil {
+		return 0, err
+	}
+	if filter.Search != "" && len(filter.IDs) == 0 {
+		if err := b.checkOpen(); err != nil {
+			return 0, err
+		}
+		filter.Limit = 0
+		count := uint32(0)
+		for range b.QueryEvents(filter, 0) {
+			count++
+		}
+		return count, nil
+	}
+
+	count := uint32(0)
+	err := b.view(func(ctx context.Context, store *idb.ObjectStore) error {
+		if len(filter.IDs) > 0 {
+			for _, id := range filter.IDs {
+				rawID, err := safejs.ValueOf(id.Hex())
+				if err != nil {
+					return err
+				}
+				req, err := store.CountKey(rawID)
+				if err != nil {
+					return err
+				}
+				n, err := req.Await(ctx)
+				if err != nil {
+					return err
+				}
+				count += uint32(n)
+			}
+			return nil
+		}
+
+		if len(filter.Tags) > 0 {
+			idx, err := store.Index(idxKindTagAuthor)
+			if err != nil {
+				return err
+			}
+			for _, kind := range filter.Kinds {
+				for tagSymbol, tags := range filter.Tags {
+					for _, tag := range tags {
+						kt := strconv.Itoa(int(kind)) + tagSymbol + tag
+						if len(filter.Authors) < 1 {
+							n, err := countTag(ctx, idx, kt)
+							if err != nil {
+								return err
+							}
+							count += n
+							continue
+						}
+						for _, author := range filter.Authors {
+							n, err := countOnly(ctx, idx, kt+author.Hex())
+							if err != nil {
+								return err
+							}
+							count += n
+						}
+					}
+				}
+			}
+			return nil
+		}
+
+		idx, err := store.Index(idxKindAuthor)
+		if err != nil {
+			return err
+		}
+		for _, kind := range filter.Kinds {
+			if len(filter.Authors) < 1 {
+				n, err := countBound(ctx, idx, []any{kind.Num()}, []any{kind.Num(), "\uffff"})
+				if err != nil {
+					return err
+				}
+				count += n
+				continue
+			}
+			for _, author := range filter.Authors {
+				n, err := countOnly(ctx, idx, []any{kind.Num(), author.Hex()})
+				if err != nil {
+					return err
+				}
+				count += n
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+// countOnly counts the entries of an index under a key.
+func countOnly(ctx context.Context, idx *idb.Index, key any) (uint32, error) {
+	only, err := safejs.ValueOf(key)
+	if err != nil {
+		return 0, err
+	}
+	rb, err := idb.NewKeyRangeOnly(only)
+	if err != nil {
+		return 0, err
+	}
+	return countRange(ctx, idx, rb)
+}
+
+// countBound counts the entries of an index between two keys, both included.
+func countBound(ctx context.Context, idx *idb.Index, lower, upper any) (uint32, error) {
+	lower_, err := safejs.ValueOf(lower)
+	if err != nil {
+		return 0, err
+	}
+	upper_, err := safejs.ValueOf(upper)
+	if err != nil {
+		return 0, err
+	}
+	rb, err := idb.NewKeyRangeBound(lower_, upper_, false, false)
+	if err != nil {
+		return 0, err
+	}
+	return countRange(ctx, idx, rb)
+}
+
+// countTag counts the entries of the tag index for a kind and tag of any
+// author. a longer tag value sharing the prefix may sort between the bounds,
+// so the length of every key is checked.
+func countTag(ctx context.Context, idx *idb.Index, kt string) (uint32, error) {
+	lower, err := safejs.ValueOf(kt + strings.Repeat("0", 64))
+	if err != nil {
+		return 0, err
+	}
+	upper, err := safejs.ValueOf(kt + strings.Repeat("f", 64))
+	if err != nil {
+		return 0, err
+	}
+	rb, err := idb.NewKeyRangeBound(lower, upper, false, false)
+	if err != nil {
+		return 0, err
+	}
+	req, err := idx.OpenKeyCursorRange(rb, idb.CursorNext)
+	if err != nil {
+		return 0, err
+	}
+	count := uint32(0)
+	err = req.Iter(ctx, func(cursor *idb.Cursor) error {
+		key, err := cursor.Key()
+		if err != nil {
+			return err
+		}
+		k, err := key.String()
+		if err != nil {
+			return err
+		}
+		if len(k) == len(kt)+64 {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+func countRange(ctx context.Context, idx *idb.Index, rb *idb.KeyRange) (uint32, error) {
+	req, err := idx.CountRange(rb)
+	if err != nil {
+		return 0, err
+	}
+	n, err := req.Await(ctx)
+	return uint32(n), err
 }
@@ -0,0 +1,64 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"testing"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/sdk"
+)
+
+func TestCountEvents(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jack, _, err := db.saveProfile(sdk.ProfileMetadata{Name: "jack"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, bob, err := db.saveProfile(sdk.ProfileMetadata{Name: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, group, err := db.saveGroupMeta("asdf", "ASDF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// longer values sharing the prefix, sorting after and between its keys
+	for _, id := range []string{"asdfx", "asdf1"} {
+		if _, _, err := db.saveGroupMeta(id, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		name   string
+		filter nostr.Filter
+		count  uint32
+	}{
+		{"ids", nostr.Filter{IDs: []nostr.ID{nostr.MustIDFromHex(jack), {}}}, 1},
+		{"kinds", nostr.Filter{Kinds: []nostr.Kind{0, 1}}, 2},
+		{"authors", nostr.Filter{Kinds: []nostr.Kind{0}, Authors: []nostr.PubKey{nostr.MustPubKeyFromHex(bob)}}, 1},
+		{"tags", nostr.Filter{Kinds: []nostr.Kind{nostr.KindSimpleGroupMetadata}, Tags: nostr.TagMap{"d": []string{"asdf"}}}, 1},
+		{"tags and authors", nostr.Filter{
+			Kinds:   []nostr.Kind{nostr.KindSimpleGroupMetadata},
+			Authors: []nostr.PubKey{nostr.MustPubKeyFromHex(bob), nostr.MustPubKeyFromHex(group)},
+			Tags:    nostr.TagMap{"d": []string{"asdf"}},
+		}, 1},
+		{"search", nostr.Filter{Kinds: []nostr.Kind{0}, Search: "jack"}, 1},
+	} {
+		count, err := db.CountEvents(test.filter)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if count != test.count {
+			t.Fatalf("%s: expected: %d, actual: %d", test.name, test.count, count)
+		}
+	}
+
+	if _, err := db.CountEvents(nostr.Filter{Since: 1}); err == nil {
+		t.Fatal("expected an invalid filter to fail")
+	}
+}
@@ -4,7 +4,6 @@ package indexeddb
 
 import (
 	"context"
-	"syscall/js"
 
 	"fiatjaf.com/nostr"
 	"github.com/aperturerobotics/go-indexeddb/idb"
@@ -12,26 +11,36 @@ import (
 )
 
 func (b *IndexeddbBackend) DeleteEvent(id nostr.ID) error {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	tx, err := b.db.Transaction(idb.TransactionReadWrite, storeNameEvents)
-	if err != nil {
-		return err
+	return b.update(func(ctx context.Context, store *idb.ObjectStore) ([]Change, error) {
+		deleted, found, err := deleteEvent(ctx, store, id)
+		if err != nil || !found {
+			return nil, err
+		}
+		return []Change{{Op: ChangeDelete, Event: deleted}}, nil
+	})
+}
+
+// deleteEvent deletes an event by id, leaving a tombstone for incremental
+// backups. it reads the event first so that the change can tell what was
+// deleted.
+func deleteEvent(ctx context.Context, store *idb.ObjectStore, id nostr.ID) (nostr.Event, bool, error) {
+	evt, found, err := getEvent(ctx, store, id)
+	if err != nil || !found {
+		return evt, found, err
 	}
-	store, err := tx.ObjectStore(storeNameEvents)
+	rawID, err := safejs.ValueOf(id.Hex())
 	if err != nil {
-		return err
+		return evt, false, err
 	}
-	req, err := store.Delete(safejs.Safe(js.ValueOf(id.Hex())))
+	req, err := store.Delete(rawID)
 	if err != nil {
-		return err
+		return evt, false, err
 	}
 	if err := req.Await(ctx); err != nil {
-		return err
+		return evt, false, err
 	}
-
-	if err := tx.Await(ctx); err != nil {
-		return err
+	if err := putTombstone(ctx, store, evt); err != nil {
+		return evt, false, err
 	}
-	return nil
+	return evt, true, nil
 }
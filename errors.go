@@ -0,0 +1,15 @@
+//go:build js
+
+package indexeddb
+
+import "errors"
+
+var (
+	ErrNotFound = errors.New("not found")
+	// ErrClosed is returned by the operations on a backend not initialized
+	// or closed.
+	ErrClosed = errors.New("store is closed")
+	// ErrBlocked is returned when opening or deleting the database waits too
+	// long for the other connections to it, e.g. of other tabs, to close.
+	ErrBlocked = errors.New("blocked by another connection to the database")
+)
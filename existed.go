@@ -10,7 +10,12 @@ import (
 )
 
 func (b *IndexeddbBackend) IsExisted(ctx context.Context, eventID string) bool {
-	tx, err := b.db.Transaction(idb.TransactionReadOnly, storeNameEvents)
+	if err := b.acquire(); err != nil {
+		logErr(err)
+		return false
+	}
+	defer b.release()
+	tx, err := b.transaction(idb.TransactionReadOnly)
 	if err != nil {
 		logErr(err)
 		return false
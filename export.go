@@ -0,0 +1,240 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/aperturerobotics/go-indexeddb/idb"
+	"github.com/hack-pad/safejs"
+)
+
+const (
+	// events are exported and imported this many per transaction, so that
+	// writing or reading them never happens while a transaction is open
+	exportBatch = 500
+	importBatch = 200
+)
+
+// ImportSummary tells what an import did.
+type ImportSummary struct {
+	// Lines is the number of lines read and committed. an interrupted import
+	// committed every line before it.
+	Lines int
+	// Saved is the number of events saved, Replaced the number of older
+	// versions they replaced.
+	Saved    int
+	Replaced int
+	// Outdated is the number of events already stored or older than the
+	// stored version.
+	Outdated int
+	// Skipped is the number of events of kinds that aren't stored.
+	Skipped int
+	// Deleted is the number of events deleted by the tombstones of an
+	// incremental backup.
+	Deleted int
+	// Invalid is the number of lines that aren't an event with a valid id
+	// and signature.
+	Invalid int
+}
+
+// Export writes the stored events matching the filter, all of them when it is
+// empty, as NIP-01 JSON lines ordered by id. it returns the number written.
+func (b *IndexeddbBackend) Export(w io.Writer, filter nostr.Filter) (int, error) {
+	bw := bufio.NewWriter(w)
+	n := 0
+	after := ""
+	for {
+		var batch []nostr.Event
+		var err error
+		if err := b.view(func(ctx context.Context, store *idb.ObjectStore) error {
+			batch, after, err = scanEvents(ctx, store, after, exportBatch)
+			return err
+		}); err != nil {
+			return n, err
+		}
+		for _, evt := range batch {
+			if !matches(filter, evt) {
+				continue
+			}
+			line, err := json.Marshal(evt)
+			if err != nil {
+				return n, err
+			}
+			if _, err := bw.Write(append(line, '\n')); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if len(batch) < exportBatch {
+			return n, bw.Flush()
+		}
+	}
+}
+
+// Import reads NIP-01 JSON lines and saves the events with the same rules as
+// ReplaceEvent for the replaceable and addressable kinds and SaveEvent for the
+// others, a batch of lines per transaction. the restored events were never
+// fetched, so Stale reports them until they are. the tombstones of
+// ExportSince delete their event. importing is idempotent, so
+// an interrupted import resumes by importing the same lines again, or only the
+// ones after summary.Lines.
+func (b *IndexeddbBackend) Import(r io.Reader) (ImportSummary, error) {
+	summary := ImportSummary{}
+	br := bufio.NewReader(r)
+	for {
+		batch := make([]importItem, 0, importBatch)
+		lines := 0
+		var readErr error
+		for len(batch) < importBatch {
+			line, err := br.ReadBytes('\n')
+			if len(line) > 0 {
+				lines++
+				// a line is a tombstone when it has a deleted id, an event otherwise
+				var evt nostr.Event
+				var ts tombstone
+				if err := json.Unmarshal(line, &ts); err != nil {
+					summary.Invalid++
+				} else if ts.Deleted != "" {
+					if id, err := nostr.IDFromHex(ts.Deleted); err != nil {
+						summary.Invalid++
+					} else {
+						batch = append(batch, importItem{deleted: id})
+					}
+				} else if err := json.Unmarshal(line, &evt); err != nil || !evt.CheckID() || !evt.VerifySignature() {
+					summary.Invalid++
+				} else if !isStored(evt.Kind) {
+					summary.Skipped++
+				} else {
+					batch = append(batch, importItem{evt: evt})
+				}
+			}
+			if err != nil {
+				readErr = err
+				break
+			}
+		}
+
+		var changes []Change
+		outdated := 0
+		if err := b.update(func(ctx context.Context, store *idb.ObjectStore) ([]Change, error) {
+			changes = []Change{}
+			outdated = 0
+			for _, item := range batch {
+				if item.deleted != nostr.ZeroID {
+					evt, found, err := deleteEvent(ctx, store, item.deleted)
+					if err != nil {
+						return nil, err
+					}
+					if found {
+						changes = append(changes, Change{Op: ChangeDelete, Event: evt})
+					}
+					continue
+				}
+				if !item.evt.Kind.IsReplaceable() && !item.evt.Kind.IsAddressable() {
+					// kept side by side, the older events of the author included
+					found, err := hasEvent(ctx, store, item.evt.ID)
+					if err != nil {
+						return nil, err
+					}
+					if found {
+						outdated++
+						continue
+					}
+					if err := putEvent(ctx, store, item.evt, time.Time{}); err != nil {
+						return nil, err
+					}
+					changes = append(changes, Change{Op: ChangeSave, Event: item.evt})
+					continue
+				}
+				replaced, stored, err := replaceEvent(ctx, store, item.evt, time.Time{})
+				if err != nil {
+					return nil, err
+				}
+				if stored {
+					changes = append(changes, replaceChange(item.evt, replaced))
+				} else {
+					outdated++
+				}
+			}
+			return changes, nil
+		}); err != nil {
+			return summary, fmt.Errorf("failed to import after line %d: %w", summary.Lines, err)
+		}
+		summary.Lines += lines
+		summary.Outdated += outdated
+		for _, change := range changes {
+			if change.Op == ChangeDelete {
+				summary.Deleted++
+			} else {
+				summary.Saved++
+				summary.Replaced += len(change.Replaced)
+			}
+		}
+
+		if errors.Is(readErr, io.EOF) {
+			return summary, nil
+		}
+		if readErr != nil {
+			return summary, readErr
+		}
+	}
+}
+
+// importItem is an event to save or, when deleted isn't zero, a tombstone.
+type importItem struct {
+	evt     nostr.Event
+	deleted nostr.ID
+}
+
+// hasEvent tells if an event is stored.
+func hasEvent(ctx context.Context, store *idb.ObjectStore, id nostr.ID) (bool, error) {
+	rawID, err := safejs.ValueOf(id.Hex())
+	if err != nil {
+		return false, err
+	}
+	req, err := store.CountKey(rawID)
+	if err != nil {
+		return false, err
+	}
+	n, err := req.Await(ctx)
+	return n > 0, err
+}
+
+// scanEvents reads up to limit events in id order, after the given id or from
+// the start when it's empty. it returns the id to continue after.
+func scanEvents(ctx context.Context, store *idb.ObjectStore, after string, limit int) ([]nostr.Event, string, error) {
+	var req *idb.CursorWithValueRequest
+	if after == "" {
+		var err error
+		if req, err = store.OpenCursor(idb.CursorNext); err != nil {
+			return nil, after, err
+		}
+	} else {
+		lower, err := safejs.ValueOf(after)
+		if err != nil {
+			return nil, after, err
+		}
+		rb, err := idb.NewKeyRangeLowerBound(lower, true)
+		if err != nil {
+			return nil, after, err
+		}
+		if req, err = store.OpenCursorRange(rb, idb.CursorNext); err != nil {
+			return nil, after, err
+		}
+	}
+	events := []nostr.Event{}
+	err := handleRequest(ctx, func(evt nostr.Event) bool {
+		events = append(events, evt)
+		after = evt.ID.Hex()
+		return len(events) < limit
+	}, req)
+	return events, after, err
+}
@@ -0,0 +1,146 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"fiatjaf.com/nostr"
+)
+
+func TestExportImport(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk := nostr.Generate()
+	saved := map[nostr.ID]nostr.Event{}
+	for _, evt := range []nostr.Event{
+		{Kind: nostr.KindProfileMetadata, Content: `{"name":"jack","about":"line\nbreak ☕"}`},
+		{Kind: nostr.KindFollowList, Tags: nostr.Tags{{"p", nostr.Generate().Public().Hex(), "wss://relay.example.com", "pet"}}},
+		{Kind: nostr.KindSimpleGroupMetadata, Tags: nostr.Tags{{"d", "devs"}, {"name", "Devs"}, {"private"}, {}}},
+	} {
+		evt.CreatedAt = nostr.Now()
+		if err := evt.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.ReplaceEvent(evt); err != nil {
+			t.Fatal(err)
+		}
+		saved[evt.ID] = evt
+	}
+
+	var buf bytes.Buffer
+	n, err := db.Export(&buf, nostr.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(saved) {
+		t.Fatalf("expected: %d exported, actual: %d", len(saved), n)
+	}
+	backup := buf.String()
+
+	var follows bytes.Buffer
+	if n, err := db.Export(&follows, nostr.Filter{Kinds: []nostr.Kind{nostr.KindFollowList}}); err != nil || n != 1 {
+		t.Fatalf("expected: 1 follow list exported, actual: %d, %v", n, err)
+	}
+
+	if err := db.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := db.Import(strings.NewReader(backup + "not json\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Lines != len(saved)+1 || summary.Saved != len(saved) || summary.Invalid != 1 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+	buf.Reset()
+	if _, err := db.Export(&buf, nostr.Filter{}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != backup {
+		t.Fatalf("expected: %s, actual: %s", backup, buf.String())
+	}
+	for line := range strings.Lines(backup) {
+		var evt nostr.Event
+		if err := json.Unmarshal([]byte(line), &evt); err != nil {
+			t.Fatal(err)
+		}
+		if evt.String() != saved[evt.ID].String() || !evt.VerifySignature() {
+			t.Fatalf("expected: %s, actual: %s", saved[evt.ID], evt)
+		}
+	}
+
+	// importing again changes nothing
+	summary, err = db.Import(strings.NewReader(backup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Saved != 0 || summary.Outdated != len(saved) {
+		t.Fatalf("unexpected summary of the second import: %+v", summary)
+	}
+}
+
+func TestImportRegular(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk := nostr.Generate()
+	for _, url := range []string{"wss://relay.damus.io", "wss://nos.lol"} {
+		evt := nostr.Event{Kind: nostr.KindRecommendServer, Content: `{"url":"` + url + `"}`, CreatedAt: nostr.Now()}
+		if err := evt.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveEvent(evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if n, err := db.Export(&buf, nostr.Filter{}); err != nil || n != 2 {
+		t.Fatalf("expected: 2 exported, actual: %d, %v", n, err)
+	}
+	backup := buf.String()
+
+	forged := nostr.Event{Kind: nostr.KindRecommendServer, Content: `{"url":"wss://forged.example.com"}`, CreatedAt: nostr.Now()}
+	if err := forged.Sign(nostr.Generate()); err != nil {
+		t.Fatal(err)
+	}
+	forged.PubKey = sk.Public()
+	forged.ID = forged.GetID()
+	line, err := json.Marshal(forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := db.Import(strings.NewReader(backup + string(line) + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// both events of the same author are kept, the forged one isn't
+	if summary.Saved != 2 || summary.Replaced != 0 || summary.Invalid != 1 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+	buf.Reset()
+	if _, err := db.Export(&buf, nostr.Filter{}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != backup {
+		t.Fatalf("expected: %s, actual: %s", backup, buf.String())
+	}
+
+	summary, err = db.Import(strings.NewReader(backup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Saved != 0 || summary.Outdated != 2 {
+		t.Fatalf("unexpected summary of the second import: %+v", summary)
+	}
+}
@@ -0,0 +1,124 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"context"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/aperturerobotics/go-indexeddb/idb"
+	"github.com/hack-pad/safejs"
+)
+
+// FetchedAt returns when a stored event was last saved, duplicates included,
+// the zero time for the imported ones, or ErrNotFound.
+func (b *IndexeddbBackend) FetchedAt(id nostr.ID) (time.Time, error) {
+	var at time.Time
+	err := b.view(func(ctx context.Context, store *idb.ObjectStore) error {
+		rawID, err := safejs.ValueOf(id.Hex())
+		if err != nil {
+			return err
+		}
+		req, err := store.Get(rawID)
+		if err != nil {
+			return err
+		}
+		value, err := req.Await(ctx)
+		if err != nil {
+			return err
+		}
+		if value.IsUndefined() || value.IsNull() {
+			return ErrNotFound
+		}
+		fa, err := value.Get(keyFetchedAt)
+		if err != nil {
+			return err
+		}
+		ms, err := fa.Float()
+		if err != nil {
+			return err
+		}
+		if ms > 0 {
+			at = time.UnixMilli(int64(ms))
+		}
+		return nil
+	})
+	return at, err
+}
+
+// Stale returns the authors of the stored events of a kind that weren't
+// fetched again for maxAge, the stalest first, e.g. the profiles (0) or relay
+// lists (10002) to refresh.
+func (b *IndexeddbBackend) Stale(kind nostr.Kind, maxAge time.Duration) ([]nostr.PubKey, error) {
+	return b.staleSince(kind, time.Now().Add(-maxAge))
+}
+
+// staleSince returns the authors of the events of a kind fetched before the
+// cutoff.
+func (b *IndexeddbBackend) staleSince(kind nostr.Kind, cutoff time.Time) ([]nostr.PubKey, error) {
+	pubkeys := []nostr.PubKey{}
+	seen := map[nostr.PubKey]bool{}
+	err := b.view(func(ctx context.Context, store *idb.ObjectStore) error {
+		idx, err := store.Index(idxKindFetched)
+		if err != nil {
+			return err
+		}
+		lower, err := safejs.ValueOf([]any{kind.Num()})
+		if err != nil {
+			return err
+		}
+		upper, err := safejs.ValueOf([]any{kind.Num(), float64(cutoff.UnixMilli())})
+		if err != nil {
+			return err
+		}
+		rb, err := idb.NewKeyRangeBound(lower, upper, false, true)
+		if err != nil {
+			return err
+		}
+		req, err := idx.OpenCursorRange(rb, idb.CursorNext)
+		if err != nil {
+			return err
+		}
+		return handleRequest(ctx, func(evt nostr.Event) bool {
+			if !seen[evt.PubKey] {
+				seen[evt.PubKey] = true
+				pubkeys = append(pubkeys, evt.PubKey)
+			}
+			return true
+		}, req)
+	})
+	return pubkeys, err
+}
+
+// touchEvent sets the fetched time of a stored event.
+func touchEvent(ctx context.Context, store *idb.ObjectStore, id nostr.ID, fetchedAt time.Time) error {
+	rawID, err := safejs.ValueOf(id.Hex())
+	if err != nil {
+		return err
+	}
+	req, err := store.Get(rawID)
+	if err != nil {
+		return err
+	}
+	value, err := req.Await(ctx)
+	if err != nil {
+		return err
+	}
+	if value.IsUndefined() || value.IsNull() {
+		return nil
+	}
+	if err := value.Set(keyFetchedAt, fetchedMillis(fetchedAt)); err != nil {
+		return err
+	}
+	_, err = store.PutKey(rawID, value)
+	return err
+}
+
+// fetchedMillis is the stored form of a fetched time, 0 when unknown.
+func fetchedMillis(at time.Time) float64 {
+	if at.IsZero() {
+		return 0
+	}
+	return float64(at.UnixMilli())
+}
@@ -0,0 +1,103 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"fiatjaf.com/nostr"
+)
+
+func TestFetched(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk1 := nostr.Generate()
+	sk2 := nostr.Generate()
+
+	profile1, err := db.saveSigned(sk1, nostr.KindFollowList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.saveSigned(sk2, nostr.KindFollowList); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	cutoff := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	stale, err := db.staleSince(nostr.KindFollowList, cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 2 {
+		t.Fatalf("expected: 2 stale pubkeys, actual: %v", stale)
+	}
+
+	// a duplicate refreshes the stored event
+	if err := db.ReplaceEvent(profile1); err != nil {
+		t.Fatal(err)
+	}
+	at, err := db.FetchedAt(profile1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !at.After(cutoff) {
+		t.Fatalf("expected the fetched time to be refreshed: %v", at)
+	}
+	stale, err = db.staleSince(nostr.KindFollowList, cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 1 || stale[0] != sk2.Public() {
+		t.Fatalf("expected: %s, actual: %v", sk2.Public(), stale)
+	}
+	stale, err = db.Stale(nostr.KindRelayListMetadata, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 0 {
+		t.Fatalf("expected no stale relay lists: %v", stale)
+	}
+
+	if _, err := db.FetchedAt(nostr.ZeroID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected: %v, actual: %v", ErrNotFound, err)
+	}
+}
+
+func TestFetchedImport(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	evt, err := db.saveSigned(nostr.Generate(), nostr.KindRelayListMetadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := db.Export(&buf, nostr.Filter{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Import(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restored event was never fetched here
+	if at, err := db.FetchedAt(evt.ID); err != nil || !at.IsZero() {
+		t.Fatalf("expected: zero time, actual: %v, %v", at, err)
+	}
+	stale, err := db.Stale(nostr.KindRelayListMetadata, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 1 || stale[0] != evt.PubKey {
+		t.Fatalf("expected: [%s], actual: %v", evt.PubKey, stale)
+	}
+}
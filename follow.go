@@ -0,0 +1,136 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"context"
+
+	"fiatjaf.com/nostr"
+	"github.com/aperturerobotics/go-indexeddb/idb"
+)
+
+// Follows returns the pubkeys in the stored contact list (kind 3) of a pubkey.
+func (b *IndexeddbBackend) Follows(pubkey nostr.PubKey) ([]nostr.PubKey, error) {
+	lists, err := b.getReplaceables(nostr.KindFollowList, []nostr.PubKey{pubkey})
+	if err != nil {
+		return nil, err
+	}
+	return taggedPubKeys(lists[pubkey]), nil
+}
+
+// Followers returns the authors of the stored contact lists following a pubkey.
+func (b *IndexeddbBackend) Followers(pubkey nostr.PubKey) ([]nostr.PubKey, error) {
+	return b.taggedBy(nostr.KindFollowList, pubkey)
+}
+
+// MutualFollows returns the pubkeys that a pubkey follows and that follow it back.
+func (b *IndexeddbBackend) MutualFollows(pubkey nostr.PubKey) ([]nostr.PubKey, error) {
+	follows, err := b.Follows(pubkey)
+	if err != nil {
+		return nil, err
+	}
+	followers, err := b.Followers(pubkey)
+	if err != nil {
+		return nil, err
+	}
+	return intersect(follows, followers), nil
+}
+
+// FollowedByFollows returns the pubkeys followed by root that follow target,
+// for "followed by people you follow".
+func (b *IndexeddbBackend) FollowedByFollows(root, target nostr.PubKey) ([]nostr.PubKey, error) {
+	follows, err := b.Follows(root)
+	if err != nil {
+		return nil, err
+	}
+	followers, err := b.Followers(target)
+	if err != nil {
+		return nil, err
+	}
+	return intersect(follows, followers), nil
+}
+
+// FollowsOfFollows expands the follows of a pubkey one step: it returns the
+// pubkeys its follows follow, with how many of them do, leaving out the pubkey
+// and its direct follows. the contact lists are read in a single transaction.
+func (b *IndexeddbBackend) FollowsOfFollows(pubkey nostr.PubKey) (map[nostr.PubKey]int, error) {
+	follows, err := b.Follows(pubkey)
+	if err != nil {
+		return nil, err
+	}
+	lists, err := b.getReplaceables(nostr.KindFollowList, follows)
+	if err != nil {
+		return nil, err
+	}
+	direct := make(map[nostr.PubKey]struct{}, len(follows)+1)
+	direct[pubkey] = struct{}{}
+	for _, follow := range follows {
+		direct[follow] = struct{}{}
+	}
+	counts := map[nostr.PubKey]int{}
+	for _, list := range lists {
+		for _, second := range taggedPubKeys(list) {
+			if _, ok := direct[second]; !ok {
+				counts[second]++
+			}
+		}
+	}
+	return counts, nil
+}
+
+// taggedBy returns the authors of the stored events of a kind with a `p` tag
+// of the pubkey.
+func (b *IndexeddbBackend) taggedBy(kind nostr.Kind, pubkey nostr.PubKey) ([]nostr.PubKey, error) {
+	authors := []nostr.PubKey{}
+	err := b.view(func(ctx context.Context, store *idb.ObjectStore) error {
+		idx, err := store.Index(idxKindTagAuthor)
+		if err != nil {
+			return err
+		}
+		req, err := openTagPrefix(idx, kind, "p", pubkey.Hex())
+		if err != nil {
+			return err
+		}
+		seen := map[nostr.PubKey]struct{}{}
+		return handleRequest(ctx, func(evt nostr.Event) bool {
+			if _, ok := seen[evt.PubKey]; !ok {
+				seen[evt.PubKey] = struct{}{}
+				authors = append(authors, evt.PubKey)
+			}
+			return true
+		}, req)
+	})
+	return authors, err
+}
+
+// taggedPubKeys returns the valid pubkeys in the `p` tags of an event.
+func taggedPubKeys(evt nostr.Event) []nostr.PubKey {
+	pubkeys := []nostr.PubKey{}
+	seen := map[nostr.PubKey]struct{}{}
+	for tag := range evt.Tags.FindAll("p") {
+		pubkey, err := nostr.PubKeyFromHex(tag[1])
+		if err != nil {
+			continue
+		}
+		if _, ok := seen[pubkey]; !ok {
+			seen[pubkey] = struct{}{}
+			pubkeys = append(pubkeys, pubkey)
+		}
+	}
+	return pubkeys
+}
+
+// intersect keeps the pubkeys of a that are also in b, in the order of a.
+func intersect(a, b []nostr.PubKey) []nostr.PubKey {
+	in := make(map[nostr.PubKey]struct{}, len(b))
+	for _, pubkey := range b {
+		in[pubkey] = struct{}{}
+	}
+	res := []nostr.PubKey{}
+	for _, pubkey := range a {
+		if _, ok := in[pubkey]; ok {
+			res = append(res, pubkey)
+		}
+	}
+	return res
+}
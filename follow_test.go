@@ -0,0 +1,91 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"slices"
+	"testing"
+
+	"fiatjaf.com/nostr"
+)
+
+func TestFollows(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob, carol, dave := nostr.Generate(), nostr.Generate(), nostr.Generate(), nostr.Generate().Public()
+	follow := func(sk nostr.SecretKey, pubkeys ...nostr.PubKey) {
+		t.Helper()
+		tags := nostr.Tags{}
+		for _, pubkey := range pubkeys {
+			tags = append(tags, nostr.Tag{"p", pubkey.Hex()})
+		}
+		if _, err := db.saveSigned(sk, nostr.KindFollowList, tags...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	follow(alice, bob.Public(), carol.Public())
+	follow(bob, alice.Public(), dave)
+	follow(carol, dave, bob.Public())
+
+	follows, err := db.Follows(alice.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(follows, []nostr.PubKey{bob.Public(), carol.Public()}) {
+		t.Fatalf("unexpected follows: %v", follows)
+	}
+
+	followers, err := db.Followers(dave)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(followers) != 2 || !slices.Contains(followers, bob.Public()) || !slices.Contains(followers, carol.Public()) {
+		t.Fatalf("unexpected followers: %v", followers)
+	}
+
+	mutuals, err := db.MutualFollows(alice.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(mutuals, []nostr.PubKey{bob.Public()}) {
+		t.Fatalf("unexpected mutuals: %v", mutuals)
+	}
+
+	via, err := db.FollowedByFollows(alice.Public(), dave)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(via, []nostr.PubKey{bob.Public(), carol.Public()}) {
+		t.Fatalf("unexpected followed by follows: %v", via)
+	}
+
+	second, err := db.FollowsOfFollows(alice.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second) != 1 || second[dave] != 2 {
+		t.Fatalf("unexpected follows of follows: %v", second)
+	}
+
+	// the old contact list no longer counts once replaced
+	newer := nostr.Event{
+		Kind:      nostr.KindFollowList,
+		CreatedAt: nostr.Now() + 1,
+		Tags:      nostr.Tags{{"p", alice.Public().Hex()}},
+	}
+	if err := newer.Sign(bob); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ReplaceEvent(newer); err != nil {
+		t.Fatal(err)
+	}
+	followers, err = db.Followers(dave)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(followers, []nostr.PubKey{carol.Public()}) {
+		t.Fatalf("unexpected followers after replace: %v", followers)
+	}
+}
@@ -0,0 +1,252 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip29"
+	"github.com/aperturerobotics/go-indexeddb/idb"
+)
+
+// GetGroup returns a NIP-29 group of a relay with its metadata (39000), admins
+// (39001), members (39002) and roles (39003) merged in, or ErrNotFound.
+// the relay pubkey signing them is read from the stored relay info.
+func (b *IndexeddbBackend) GetGroup(relay, id string) (nip29.Group, error) {
+	groups, err := b.groups(relay, id)
+	if err != nil {
+		return nip29.Group{}, err
+	}
+	if len(groups) == 0 {
+		return nip29.Group{}, ErrNotFound
+	}
+	return groups[0], nil
+}
+
+// ListGroups returns every stored group of a relay.
+func (b *IndexeddbBackend) ListGroups(relay string) ([]nip29.Group, error) {
+	return b.groups(relay, "")
+}
+
+// GroupAdmins returns the admins of a group with their role names.
+func (b *IndexeddbBackend) GroupAdmins(relay, id string) (map[nostr.PubKey][]string, error) {
+	group, err := b.GetGroup(relay, id)
+	if err != nil {
+		return nil, err
+	}
+	admins := map[nostr.PubKey][]string{}
+	for member, roles := range group.Members {
+		if len(roles) == 0 {
+			continue
+		}
+		pubkey, err := nostr.PubKeyFromHex(member)
+		if err != nil {
+			continue
+		}
+		for _, role := range roles {
+			admins[pubkey] = append(admins[pubkey], role.Name)
+		}
+	}
+	return admins, nil
+}
+
+// GroupMembers returns the members of a group, admins included.
+func (b *IndexeddbBackend) GroupMembers(relay, id string) ([]nostr.PubKey, error) {
+	group, err := b.GetGroup(relay, id)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]nostr.PubKey, 0, len(group.Members))
+	for member := range group.Members {
+		if pubkey, err := nostr.PubKeyFromHex(member); err == nil {
+			members = append(members, pubkey)
+		}
+	}
+	slices.SortFunc(members, func(a, b nostr.PubKey) int { return bytes.Compare(a[:], b[:]) })
+	return members, nil
+}
+
+// JoinedGroups returns the groups, on any relay, whose stored member list has
+// the pubkey. the relay of a group is left empty when we don't have the info
+// of the relay that signed it.
+func (b *IndexeddbBackend) JoinedGroups(pubkey nostr.PubKey) ([]nip29.Group, error) {
+	infos, err := b.QueryRelayInfos(RelayQuery{})
+	if err != nil {
+		return nil, err
+	}
+	relays := map[nostr.PubKey]string{}
+	for _, info := range infos {
+		if info.PubKey != nostr.ZeroPK {
+			relays[info.PubKey] = info.URL
+		}
+	}
+
+	groups := []nip29.Group{}
+	err = b.view(func(ctx context.Context, store *idb.ObjectStore) error {
+		idx, err := store.Index(idxKindTagAuthor)
+		if err != nil {
+			return err
+		}
+		req, err := openTagPrefix(idx, nostr.KindSimpleGroupMembers, "p", pubkey.Hex())
+		if err != nil {
+			return err
+		}
+		memberships, err := collect(ctx, req)
+		if err != nil {
+			return err
+		}
+		for _, membership := range memberships {
+			events, err := groupEvents(ctx, store, membership.PubKey, membership.Tags.GetD())
+			if err != nil {
+				return err
+			}
+			groups = append(groups, mergeGroups(relays[membership.PubKey], events)...)
+		}
+		return nil
+	})
+	return groups, err
+}
+
+// groups reads the groups of a relay, a single one when id isn't empty.
+func (b *IndexeddbBackend) groups(relay, id string) ([]nip29.Group, error) {
+	relay = nostr.NormalizeURL(relay)
+	info, err := b.GetRelayInfo(relay)
+	if err != nil {
+		return nil, fmt.Errorf("relay info of %s: %w", relay, err)
+	}
+	if info.PubKey == nostr.ZeroPK {
+		return nil, fmt.Errorf("relay info of %s has no pubkey: %w", relay, ErrNotFound)
+	}
+
+	var groups []nip29.Group
+	err = b.view(func(ctx context.Context, store *idb.ObjectStore) error {
+		events, err := groupEvents(ctx, store, info.PubKey, id)
+		if err != nil {
+			return err
+		}
+		groups = mergeGroups(relay, events)
+		return nil
+	})
+	return groups, err
+}
+
+// groupEvents reads the group metadata events signed by a relay, of a single
+// group when id isn't empty.
+func groupEvents(ctx context.Context, store *idb.ObjectStore, relay nostr.PubKey, id string) ([]nostr.Event, error) {
+	events := []nostr.Event{}
+	for _, kind := range nip29.MetadataEventKinds {
+		var req *idb.CursorWithValueRequest
+		if id != "" {
+			idx, err := store.Index(idxKindTagAuthor)
+			if err != nil {
+				return nil, err
+			}
+			if req, err = openOnly(idx, kindTagAuthor(kind, "d", id, relay)); err != nil {
+				return nil, err
+			}
+		} else {
+			idx, err := store.Index(idxKindAuthor)
+			if err != nil {
+				return nil, err
+			}
+			if req, err = openOnly(idx, []any{kind.Num(), relay.Hex()}); err != nil {
+				return nil, err
+			}
+		}
+		found, err := collect(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, found...)
+	}
+	return events, nil
+}
+
+// groupKinds are the kinds of group events in the order they are merged in.
+var groupKinds = []nostr.Kind{
+	nostr.KindSimpleGroupMetadata,
+	nostr.KindSimpleGroupRoles,
+	nostr.KindSimpleGroupAdmins,
+	nostr.KindSimpleGroupMembers,
+}
+
+// hasFlag tells whether a tag without a value is there.
+func hasFlag(tags nostr.Tags, name string) bool {
+	return slices.ContainsFunc(tags, func(tag nostr.Tag) bool {
+		return len(tag) > 0 && tag[0] == name
+	})
+}
+
+// mergeGroups builds the groups that have metadata out of their events.
+func mergeGroups(relay string, events []nostr.Event) []nip29.Group {
+	// roles before the admins referring to them, then oldest first so that
+	// newer events win
+	slices.SortFunc(events, func(a, b nostr.Event) int {
+		return cmp.Or(
+			cmp.Compare(slices.Index(groupKinds, a.Kind), slices.Index(groupKinds, b.Kind)),
+			cmp.Compare(a.CreatedAt, b.CreatedAt),
+		)
+	})
+
+	groups := map[string]*nip29.Group{}
+	for _, evt := range events {
+		id := evt.Tags.GetD()
+		group, ok := groups[id]
+		if !ok {
+			group = &nip29.Group{
+				Address: nip29.GroupAddress{Relay: relay, ID: id},
+				Name:    id,
+				Members: map[string][]*nip29.Role{},
+			}
+			groups[id] = group
+		}
+		switch evt.Kind {
+		case nostr.KindSimpleGroupMetadata:
+			err := group.MergeInMetadataEvent(&evt)
+			if err != nil {
+				logErr(err)
+				continue
+			}
+			// the flags are tags without a value, which it doesn't find
+			group.Private = hasFlag(evt.Tags, "private")
+			group.Closed = hasFlag(evt.Tags, "closed")
+		case nostr.KindSimpleGroupAdmins:
+			err := group.MergeInAdminsEvent(&evt)
+			if err != nil {
+				logErr(err)
+			}
+		case nostr.KindSimpleGroupMembers:
+			err := group.MergeInMembersEvent(&evt)
+			if err != nil {
+				logErr(err)
+			}
+		case nostr.KindSimpleGroupRoles:
+			group.Roles = nil
+			for tag := range evt.Tags.FindAll("role") {
+				role := &nip29.Role{Name: tag[1]}
+				if len(tag) > 2 {
+					role.Description = tag[2]
+				}
+				group.Roles = append(group.Roles, role)
+			}
+			group.LastRolesUpdate = evt.CreatedAt
+		}
+	}
+
+	res := make([]nip29.Group, 0, len(groups))
+	for _, group := range groups {
+		if group.LastMetadataUpdate != 0 {
+			res = append(res, *group)
+		}
+	}
+	slices.SortFunc(res, func(a, b nip29.Group) int {
+		return cmp.Or(strings.Compare(a.Address.Relay, b.Address.Relay), strings.Compare(a.Address.ID, b.Address.ID))
+	})
+	return res
+}
@@ -0,0 +1,97 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"slices"
+	"testing"
+
+	"fiatjaf.com/nostr"
+)
+
+func (db *DB) saveSigned(sk nostr.SecretKey, kind nostr.Kind, tags ...nostr.Tag) (nostr.Event, error) {
+	evt := nostr.Event{
+		Kind:      kind,
+		CreatedAt: nostr.Now(),
+		Tags:      tags,
+	}
+	if err := evt.Sign(sk); err != nil {
+		return evt, err
+	}
+	return evt, db.ReplaceEvent(evt)
+}
+
+func TestGroups(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay := nostr.Generate()
+	alice, bob := nostr.Generate().Public(), nostr.Generate().Public()
+	if err := db.saveRelayDocument(map[string]any{
+		"url":    "wss://groups.example.com",
+		"pubkey": relay.Public().Hex(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, evt := range []struct {
+		kind nostr.Kind
+		tags nostr.Tags
+	}{
+		{nostr.KindSimpleGroupMetadata, nostr.Tags{{"d", "devs"}, {"name", "Devs"}, {"private"}}},
+		{nostr.KindSimpleGroupMetadata, nostr.Tags{{"d", "misc"}}},
+		{nostr.KindSimpleGroupAdmins, nostr.Tags{{"d", "devs"}, {"p", alice.Hex(), "ceo"}}},
+		{nostr.KindSimpleGroupMembers, nostr.Tags{{"d", "devs"}, {"p", alice.Hex()}, {"p", bob.Hex()}}},
+		{nostr.KindSimpleGroupMembers, nostr.Tags{{"d", "misc"}, {"p", bob.Hex()}}},
+	} {
+		if _, err := db.saveSigned(relay, evt.kind, evt.tags...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	group, err := db.GetGroup("groups.example.com", "devs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.Name != "Devs" || !group.Private || group.Address.Relay != "wss://groups.example.com" {
+		t.Fatalf("unexpected group: %s", group)
+	}
+
+	groups, err := db.ListGroups("wss://groups.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || groups[0].Address.ID != "devs" || groups[1].Address.ID != "misc" {
+		t.Fatalf("unexpected groups: %v", groups)
+	}
+
+	admins, err := db.GroupAdmins("wss://groups.example.com", "devs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(admins) != 1 || !slices.Equal(admins[alice], []string{"ceo"}) {
+		t.Fatalf("unexpected admins: %v", admins)
+	}
+	members, err := db.GroupMembers("wss://groups.example.com", "devs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || !slices.Contains(members, alice) || !slices.Contains(members, bob) {
+		t.Fatalf("unexpected members: %v", members)
+	}
+
+	joined, err := db.JoinedGroups(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(joined) != 2 {
+		t.Fatalf("expected 2 groups, actual: %v", joined)
+	}
+	joined, err = db.JoinedGroups(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(joined) != 1 || joined[0].Address.ID != "devs" || joined[0].Address.Relay != "wss://groups.example.com" {
+		t.Fatalf("unexpected groups: %v", joined)
+	}
+}
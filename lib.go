@@ -4,9 +4,15 @@ package indexeddb
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"runtime"
+	"sync"
+	"syscall/js"
+	"time"
 
+	"fiatjaf.com/nostr"
 	"fiatjaf.com/nostr/eventstore"
 	"github.com/aperturerobotics/go-indexeddb/idb"
 	"github.com/hack-pad/safejs"
@@ -15,47 +21,308 @@ import (
 var _ eventstore.Store = (*IndexeddbBackend)(nil)
 
 type IndexeddbBackend struct {
-	db *idb.Database
+	// KnownPubKey, when set, boosts search results from pubkeys the caller
+	// already knows about, e.g. follows. it's called after the search read
+	// the index, and so may use the backend, like VerifiedNIP05.
+	KnownPubKey func(pubkey nostr.PubKey) bool
+	// VerifiedNIP05, when set, boosts search results for profiles whose
+	// nip05 identifier the caller has verified.
+	VerifiedNIP05 func(pubkey nostr.PubKey, nip05 string) bool
+	// BlockedTimeout is how long opening or deleting the database waits for
+	// the other connections to close before failing with ErrBlocked.
+	BlockedTimeout time.Duration
+	// MaxTombstones is how many of the latest deletions are kept for
+	// ExportSince, defaultMaxTombstones when 0. a backup from a checkpoint
+	// older than the oldest tombstone misses the deletions before it.
+	MaxTombstones int
+	// SeenRetention is how long the relays an event was seen on are kept
+	// after it was last seen there, defaultSeenRetention when 0.
+	SeenRetention time.Duration
+	// Oplog, when set, records every save, replace and delete in the oplog
+	// store, in the same transaction as the change.
+	Oplog *OplogConfig
+
+	// conn guards the connection and the lifecycle state
+	conn  sync.Mutex
+	state state
+	// inflight counts the operations in flight, yielding those of them that
+	// wait for the loop of a QueryEvents caller
+	inflight int
+	yielding int
+	idle     sync.Cond
+	db       *idb.Database
+	trust    trustCache
+	subs     subscriptions
+	channel  *channel
 }
 
+// state is where a backend is in its lifecycle, the zero value being closed
+// until Init.
+type state int
+
+const (
+	stateClosed state = iota
+	stateOpen
+	stateClosing
+)
+
+// Init opens the database. it does nothing when already open and reopens a
+// closed backend.
 func (b *IndexeddbBackend) Init() error {
-	ctx := context.Background()
-	var err error
-	req, err := idb.Global().Open(ctx, databaseName, databaseVersion, upgrade)
-	if err != nil {
-		return err
+	b.conn.Lock()
+	defer b.conn.Unlock()
+	switch b.state {
+	case stateOpen:
+		return nil
+	case stateClosing:
+		return ErrClosed
 	}
-	b.db, err = req.Await(ctx)
-	if err != nil {
+	if err := b.open(); err != nil {
 		return err
 	}
+	b.state = stateOpen
 	return nil
 }
 
+// Close waits for the operations in flight, then closes the database and the
+// subscriptions. later operations fail with ErrClosed until Init is called
+// again. closing twice does nothing. the QueryEvents loops, Close may be called
+// from, aren't waited for: they end at their next event.
 func (b *IndexeddbBackend) Close() {
-	b.db.Close()
+	b.conn.Lock()
+	if b.state != stateOpen {
+		b.conn.Unlock()
+		return
+	}
+	b.state = stateClosing
+	for b.inflight > b.yielding {
+		b.cond().Wait()
+	}
+	b.conn.Unlock()
+
+	b.subs.closeAll()
+
+	b.conn.Lock()
+	defer b.conn.Unlock()
+	b.closeChannel()
+	if err := b.db.Close(); err != nil {
+		logErr(err)
+	}
+	b.db = nil
+	b.state = stateClosed
 }
 
+// Reset deletes the database and creates it again, opening the backend. our
+// own connection is closed first so that it doesn't block the deletion.
 func (b *IndexeddbBackend) Reset() error {
-	ctx := context.Background()
+	b.Close()
+
+	b.conn.Lock()
+	defer b.conn.Unlock()
+	if b.state != stateClosed {
+		return ErrClosed
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), b.blockedTimeout())
+	defer cancel()
 	req, err := idb.Global().DeleteDatabase(databaseName)
 	if err != nil {
 		return err
 	}
 	if err := req.Await(ctx); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			// the deletion stays pending until the other connections close
+			return fmt.Errorf("failed to delete %s: %w", databaseName, ErrBlocked)
+		}
+		return err
+	}
+	if err := b.open(); err != nil {
 		return err
 	}
-	req2, err := idb.Global().Open(ctx, databaseName, databaseVersion, upgrade)
+	b.state = stateOpen
+	b.forgetTrust()
+	return nil
+}
+
+// acquire registers an operation in flight, it fails when the backend isn't
+// open. release must be called once the operation is done.
+func (b *IndexeddbBackend) acquire() error {
+	b.conn.Lock()
+	defer b.conn.Unlock()
+	if b.state != stateOpen {
+		return ErrClosed
+	}
+	b.inflight++
+	return nil
+}
+
+// checkOpen fails with ErrClosed when the backend isn't open, for the
+// operations made of other ones which each hold their own slot.
+func (b *IndexeddbBackend) checkOpen() error {
+	b.conn.Lock()
+	defer b.conn.Unlock()
+	if b.state != stateOpen {
+		return ErrClosed
+	}
+	return nil
+}
+
+func (b *IndexeddbBackend) release() {
+	b.conn.Lock()
+	defer b.conn.Unlock()
+	b.inflight--
+	b.cond().Broadcast()
+}
+
+// park marks an operation in flight as waiting for the caller, which may be
+// closing the backend.
+func (b *IndexeddbBackend) park() {
+	b.conn.Lock()
+	defer b.conn.Unlock()
+	b.yielding++
+	b.cond().Broadcast()
+}
+
+// unpark is called once the caller returns, it tells if the operation may go
+// on.
+func (b *IndexeddbBackend) unpark() bool {
+	b.conn.Lock()
+	defer b.conn.Unlock()
+	b.yielding--
+	return b.state == stateOpen
+}
+
+// cond signals the changes of the operations in flight, b.conn being held.
+func (b *IndexeddbBackend) cond() *sync.Cond {
+	if b.idle.L == nil {
+		b.idle.L = &b.conn
+	}
+	return &b.idle
+}
+
+// open connects to the database, upgrading it when needed.
+func (b *IndexeddbBackend) open() error {
+	ctx, cancel := context.WithTimeout(context.Background(), b.blockedTimeout())
+	defer cancel()
+	// the upgrade listener lives until the request settles rather than until
+	// the timeout: a blocked open given up on still goes on once unblocked,
+	// and must not bump the version without creating the stores
+	settleCtx, settled := context.WithCancel(context.Background())
+	req, err := idb.Global().Open(settleCtx, databaseName, databaseVersion, upgrade)
 	if err != nil {
+		settled()
 		return err
 	}
-	b.db, err = req2.Await(ctx)
+	db, err := req.Await(ctx)
 	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			go closeLate(req, settled)
+			return fmt.Errorf("failed to open %s: %w", databaseName, ErrBlocked)
+		}
+		settled()
+		if errors.Is(err, idb.NewDOMException("VersionError")) {
+			return fmt.Errorf("%s was upgraded past version %d by a newer client: %w", databaseName, databaseVersion, err)
+		}
 		return err
 	}
+	settled()
+	b.db = db
+	b.openChannel()
 	return nil
 }
 
+// closeLate closes the connection a blocked open makes after it was given up
+// on, nobody using it.
+func closeLate(req *idb.OpenDBRequest, settled context.CancelFunc) {
+	defer settled()
+	var db *idb.Database
+	var err error
+	if state, _ := req.ReadyState(); state == "done" {
+		db, err = req.Result()
+	} else {
+		db, err = req.Await(context.Background())
+	}
+	if err != nil {
+		return
+	}
+	if err := db.Close(); err != nil {
+		logErr(err)
+	}
+}
+
+// transaction starts a transaction on all the stores. a connection closed
+// under us, by an upgrade or deletion from another tab or by the browser, is
+// opened again first.
+func (b *IndexeddbBackend) transaction(mode idb.TransactionMode) (*idb.Transaction, error) {
+	db := b.database()
+	tx, err := db.Transaction(mode, storeNameEvents, sideStores...)
+	if !isDOMException(err, "InvalidStateError") {
+		return tx, err
+	}
+	if err := b.reconnect(db); err != nil {
+		return nil, err
+	}
+	return b.database().Transaction(mode, storeNameEvents, sideStores...)
+}
+
+// isDOMException tells whether err is the named DOMException. the ones thrown
+// right away come as a js.Error, only those of requests are idb.DOMException.
+func isDOMException(err error, name string) bool {
+	if errors.Is(err, idb.NewDOMException(name)) {
+		return true
+	}
+	var jsErr js.Error
+	if !errors.As(err, &jsErr) || jsErr.Value.Type() != js.TypeObject {
+		return false
+	}
+	return jsErr.Value.Get("name").String() == name
+}
+
+// database is the current connection, replaced by reconnect.
+func (b *IndexeddbBackend) database() *idb.Database {
+	b.conn.Lock()
+	defer b.conn.Unlock()
+	return b.db
+}
+
+// sideStores are the stores next to the events one, in every transaction.
+var sideStores = []string{storeNameTombstones, storeNameState, storeNameOplog, storeNameSeen, storeNameMissing}
+
+// reconnect opens the database again, once for all the transactions that
+// found the connection closed at the same time.
+func (b *IndexeddbBackend) reconnect(stale *idb.Database) error {
+	b.conn.Lock()
+	defer b.conn.Unlock()
+	if b.db != stale {
+		return nil
+	}
+	logWarn("the database connection was closed, reopening it")
+	if err := b.db.Close(); err != nil {
+		logErr(err)
+	}
+	return b.open()
+}
+
+func (b *IndexeddbBackend) maxTombstones() int {
+	if b.MaxTombstones > 0 {
+		return b.MaxTombstones
+	}
+	return defaultMaxTombstones
+}
+
+func (b *IndexeddbBackend) seenRetention() time.Duration {
+	if b.SeenRetention > 0 {
+		return b.SeenRetention
+	}
+	return defaultSeenRetention
+}
+
+func (b *IndexeddbBackend) blockedTimeout() time.Duration {
+	if b.BlockedTimeout > 0 {
+		return b.BlockedTimeout
+	}
+	return defaultBlockedTimeout
+}
+
 // upgrade deletes the store if we have a new version. no migration for simplicity.
 func upgrade(db *idb.Database, oldVersion, newVersion uint) error {
 	if oldVersion < newVersion {
@@ -63,15 +330,8 @@ func upgrade(db *idb.Database, oldVersion, newVersion uint) error {
 		if err != nil {
 			return err
 		}
-		found := false
 		for _, n := range names {
-			if n == storeNameEvents {
-				found = true
-			}
-		}
-
-		if found {
-			if err := db.DeleteObjectStore(storeNameEvents); err != nil {
+			if err := db.DeleteObjectStore(n); err != nil {
 				return err
 			}
 		}
@@ -113,6 +373,124 @@ func upgrade(db *idb.Database, oldVersion, newVersion uint) error {
 		); err != nil {
 			return err
 		}
+		kpst, err := safejs.ValueOf(keySearchTerms)
+		if err != nil {
+			return nil
+		}
+		if _, err := store.CreateIndex(
+			idxSearchTerms,
+			kpst,
+			idb.IndexOptions{Unique: false, MultiEntry: true},
+		); err != nil {
+			return err
+		}
+		kprc, err := safejs.ValueOf(keyRelayCaps)
+		if err != nil {
+			return nil
+		}
+		if _, err := store.CreateIndex(
+			idxRelayCaps,
+			kprc,
+			idb.IndexOptions{Unique: false, MultiEntry: true},
+		); err != nil {
+			return err
+		}
+		kpsq, err := safejs.ValueOf(keySeq)
+		if err != nil {
+			return nil
+		}
+		if _, err := store.CreateIndex(
+			idxSeq,
+			kpsq,
+			idb.IndexOptions{Unique: false, MultiEntry: false},
+		); err != nil {
+			return err
+		}
+
+		kpca, err := safejs.ValueOf(keyCreatedAt)
+		if err != nil {
+			return nil
+		}
+		if _, err := store.CreateIndex(
+			idxCreatedAt,
+			kpca,
+			idb.IndexOptions{Unique: false, MultiEntry: false},
+		); err != nil {
+			return err
+		}
+		kpkf, err := safejs.ValueOf([]any{keyKind, keyFetchedAt})
+		if err != nil {
+			return nil
+		}
+		if _, err := store.CreateIndex(
+			idxKindFetched,
+			kpkf,
+			idb.IndexOptions{Unique: false, MultiEntry: false},
+		); err != nil {
+			return err
+		}
+
+		tombstones, err := db.CreateObjectStore(storeNameTombstones, idb.ObjectStoreOptions{AutoIncrement: false})
+		if err != nil {
+			return err
+		}
+		if _, err := tombstones.CreateIndex(
+			idxSeq,
+			kpsq,
+			idb.IndexOptions{Unique: false, MultiEntry: false},
+		); err != nil {
+			return err
+		}
+
+		if _, err := db.CreateObjectStore(storeNameState, idb.ObjectStoreOptions{AutoIncrement: false}); err != nil {
+			return err
+		}
+		if _, err := db.CreateObjectStore(storeNameOplog, idb.ObjectStoreOptions{AutoIncrement: false}); err != nil {
+			return err
+		}
+
+		seen, err := db.CreateObjectStore(storeNameSeen, idb.ObjectStoreOptions{AutoIncrement: false})
+		if err != nil {
+			return err
+		}
+		kpar, err := safejs.ValueOf([]any{keyAuthor, keyRelay})
+		if err != nil {
+			return nil
+		}
+		if _, err := seen.CreateIndex(
+			idxAuthorRelay,
+			kpar,
+			idb.IndexOptions{Unique: false, MultiEntry: false},
+		); err != nil {
+			return err
+		}
+		kpls, err := safejs.ValueOf(keyLastSeen)
+		if err != nil {
+			return nil
+		}
+		if _, err := seen.CreateIndex(
+			idxLastSeen,
+			kpls,
+			idb.IndexOptions{Unique: false, MultiEntry: false},
+		); err != nil {
+			return err
+		}
+
+		missing, err := db.CreateObjectStore(storeNameMissing, idb.ObjectStoreOptions{AutoIncrement: false})
+		if err != nil {
+			return err
+		}
+		kpex, err := safejs.ValueOf(keyExpires)
+		if err != nil {
+			return nil
+		}
+		if _, err := missing.CreateIndex(
+			idxExpires,
+			kpex,
+			idb.IndexOptions{Unique: false, MultiEntry: false},
+		); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -0,0 +1,175 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"context"
+	"errors"
+	"syscall/js"
+	"testing"
+	"time"
+
+	"fiatjaf.com/nostr"
+)
+
+func TestReconnect(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	evt, err := db.saveSigned(nostr.Generate(), nostr.KindFollowList)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// as the browser or an upgrade from another tab would
+	if err := db.db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for range db.QueryEvents(nostr.Filter{IDs: []nostr.ID{evt.ID}}, 0) {
+		found = true
+	}
+	if !found {
+		t.Fatal("event not found after reconnecting")
+	}
+}
+
+func TestBlocked(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a connection of another tab that ignores versionchange
+	opened := make(chan js.Value, 1)
+	onSuccess := js.FuncOf(func(this js.Value, args []js.Value) any {
+		opened <- this.Get("result")
+		return nil
+	})
+	defer onSuccess.Release()
+	js.Global().Get("indexedDB").Call("open", databaseName).Call("addEventListener", "success", onSuccess)
+	var other js.Value
+	select {
+	case other = <-opened:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out opening another connection")
+	}
+
+	db.BlockedTimeout = 100 * time.Millisecond
+	if err := db.Reset(); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("expected: ErrBlocked, actual: %v", err)
+	}
+
+	other.Call("close")
+	db.BlockedTimeout = 0
+	if err := db.Reset(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	db := &IndexeddbBackend{}
+	evt := nostr.Event{Kind: nostr.KindFollowList, CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	if err := evt.Sign(nostr.Generate()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveEvent(evt); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected: ErrClosed before Init, actual: %v", err)
+	}
+	for range db.QueryEvents(nostr.Filter{}, 0) {
+		t.Fatal("unexpected event before Init")
+	}
+
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveEvent(evt); err != nil {
+		t.Fatal(err)
+	}
+	changes, _ := db.Subscribe(nostr.Filter{})
+	db.Close()
+	db.Close()
+	for range changes {
+	}
+	if err := db.DeleteEvent(evt.ID); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected: ErrClosed after Close, actual: %v", err)
+	}
+
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if !db.IsExisted(context.Background(), evt.ID.Hex()) {
+		t.Fatal("event not found after reopening")
+	}
+}
+
+func TestResetCloses(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, _ := db.Subscribe(nostr.Filter{})
+	old := db.channel
+	if err := db.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-changes:
+			closed = !ok
+		case <-timeout:
+			t.Fatal("subscription still open after Reset")
+		}
+	}
+	if old == nil {
+		return
+	}
+	if db.channel == old {
+		t.Fatal("channel not reopened after Reset")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("channel still open after Reset")
+		}
+	}()
+	// posting to a closed BroadcastChannel throws
+	old.value.Call("postMessage", "")
+}
+
+func TestCloseInQuery(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk := nostr.Generate()
+	for _, d := range []string{"a", "b"} {
+		if _, err := db.saveSigned(sk, nostr.KindCategorizedPeopleList, nostr.Tag{"d", d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan int)
+	go func() {
+		n := 0
+		for range db.QueryEvents(nostr.Filter{Kinds: []nostr.Kind{nostr.KindCategorizedPeopleList}}, 0) {
+			n++
+			db.Close()
+		}
+		done <- n
+	}()
+	select {
+	case n := <-done:
+		if n != 1 {
+			t.Fatalf("expected the iteration to end after Close, got %d events", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close deadlocked in a QueryEvents loop")
+	}
+}
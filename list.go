@@ -0,0 +1,236 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"github.com/aperturerobotics/go-indexeddb/idb"
+)
+
+// List is a parsed NIP-51 list or set, with its public items only: the private
+// ones are encrypted in the content, which is kept as is.
+type List struct {
+	PubKey    nostr.PubKey
+	CreatedAt nostr.Timestamp
+	Event     nostr.Event
+
+	Kind nostr.Kind
+	// D is the identifier of a set, empty for a standard list.
+	D           string
+	Title       string
+	Description string
+	Image       string
+
+	// Items are the public item tags in order, every tag but the metadata ones.
+	Items nostr.Tags
+
+	PubKeys   []nostr.PubKey
+	EventIDs  []nostr.ID
+	Addresses []string
+	Hashtags  []string
+	Words     []string
+	Relays    []string
+}
+
+// listMetaTags are the tags of a set describing it rather than listing items.
+var listMetaTags = []string{"d", "title", "description", "image", "name"}
+
+// GetList returns the stored standard list (10000-19999) of a pubkey, e.g. its
+// mute list, or ErrNotFound.
+func (b *IndexeddbBackend) GetList(kind nostr.Kind, pubkey nostr.PubKey) (List, error) {
+	if !kind.IsReplaceable() {
+		return List{}, fmt.Errorf("kind %d is not a replaceable list", kind)
+	}
+	events, err := b.getReplaceables(kind, []nostr.PubKey{pubkey})
+	if err != nil {
+		return List{}, err
+	}
+	evt, ok := events[pubkey]
+	if !ok {
+		return List{}, ErrNotFound
+	}
+	return ParseList(evt)
+}
+
+// GetListSet returns the stored set (30000-39999) of a pubkey with the
+// identifier, e.g. a follow set, or ErrNotFound.
+func (b *IndexeddbBackend) GetListSet(kind nostr.Kind, pubkey nostr.PubKey, d string) (List, error) {
+	if !kind.IsAddressable() {
+		return List{}, fmt.Errorf("kind %d is not an addressable set", kind)
+	}
+	var events []nostr.Event
+	err := b.view(func(ctx context.Context, store *idb.ObjectStore) (err error) {
+		events, err = addressEvents(ctx, store, nostr.EntityPointer{Kind: kind, PublicKey: pubkey, Identifier: d})
+		return err
+	})
+	if err != nil {
+		return List{}, err
+	}
+	if len(events) == 0 {
+		return List{}, ErrNotFound
+	}
+	newest := events[0]
+	for _, evt := range events[1:] {
+		if isOlder(newest, evt) {
+			newest = evt
+		}
+	}
+	return ParseList(newest)
+}
+
+// ListSets returns every stored set of a kind of a pubkey, sorted by identifier.
+func (b *IndexeddbBackend) ListSets(kind nostr.Kind, pubkey nostr.PubKey) ([]List, error) {
+	if !kind.IsAddressable() {
+		return nil, fmt.Errorf("kind %d is not an addressable set", kind)
+	}
+	var events []nostr.Event
+	err := b.view(func(ctx context.Context, store *idb.ObjectStore) error {
+		idx, err := store.Index(idxKindAuthor)
+		if err != nil {
+			return err
+		}
+		req, err := openOnly(idx, []any{kind.Num(), pubkey.Hex()})
+		if err != nil {
+			return err
+		}
+		events, err = collect(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	byD := map[string]nostr.Event{}
+	for _, evt := range events {
+		d := evt.Tags.GetD()
+		if previous, ok := byD[d]; !ok || isOlder(previous, evt) {
+			byD[d] = evt
+		}
+	}
+	lists := make([]List, 0, len(byD))
+	for _, evt := range byD {
+		list, err := ParseList(evt)
+		if err != nil {
+			logErr(err)
+			continue
+		}
+		lists = append(lists, list)
+	}
+	slices.SortFunc(lists, func(a, b List) int { return strings.Compare(a.D, b.D) })
+	return lists, nil
+}
+
+// EditList returns the new list event to sign, made out of the stored list (or
+// set with the identifier d) of the pubkey with the items added and removed.
+// see EditListEvent.
+func (b *IndexeddbBackend) EditList(kind nostr.Kind, pubkey nostr.PubKey, d string, add, remove nostr.Tags) (nostr.Event, error) {
+	var current List
+	var err error
+	if kind.IsAddressable() {
+		current, err = b.GetListSet(kind, pubkey, d)
+	} else {
+		current, err = b.GetList(kind, pubkey)
+	}
+	if errors.Is(err, ErrNotFound) {
+		current.Event = nostr.Event{Kind: kind, PubKey: pubkey, Tags: nostr.Tags{}}
+		if kind.IsAddressable() {
+			current.Event.Tags = append(current.Event.Tags, nostr.Tag{"d", d})
+		}
+	} else if err != nil {
+		return nostr.Event{}, err
+	}
+	return EditListEvent(current.Event, add, remove), nil
+}
+
+// EditListEvent returns a copy of a list event with the items added and
+// removed, to be signed. items are matched by their name and value: an added
+// item already there is left as is and a removed one goes with its relay hint
+// or petname. every other tag and the content, where the private items are,
+// are kept, so that the edit doesn't lose what other clients added.
+func EditListEvent(current nostr.Event, add, remove nostr.Tags) nostr.Event {
+	evt := nostr.Event{
+		Kind:      current.Kind,
+		PubKey:    current.PubKey,
+		Content:   current.Content,
+		CreatedAt: max(nostr.Now(), current.CreatedAt+1),
+		Tags:      make(nostr.Tags, 0, len(current.Tags)+len(add)),
+	}
+	for _, tag := range current.Tags {
+		if !slices.ContainsFunc(remove, func(item nostr.Tag) bool { return sameItem(tag, item) }) {
+			evt.Tags = append(evt.Tags, slices.Clone(tag))
+		}
+	}
+	for _, item := range add {
+		if len(item) < 2 || slices.ContainsFunc(evt.Tags, func(tag nostr.Tag) bool { return sameItem(tag, item) }) {
+			continue
+		}
+		evt.Tags = append(evt.Tags, slices.Clone(item))
+	}
+	return evt
+}
+
+// ParseList reads the metadata and public items of a NIP-51 list or set event.
+func ParseList(event nostr.Event) (List, error) {
+	if !event.Kind.IsReplaceable() && !event.Kind.IsAddressable() {
+		return List{}, fmt.Errorf("event %s is kind %d, not a list", event.ID, event.Kind)
+	}
+	list := List{
+		PubKey:    event.PubKey,
+		CreatedAt: event.CreatedAt,
+		Event:     event,
+		Kind:      event.Kind,
+		Items:     nostr.Tags{},
+	}
+	for _, tag := range event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		if slices.Contains(listMetaTags, tag[0]) {
+			switch tag[0] {
+			case "d":
+				list.D = tag[1]
+			case "title", "name":
+				list.Title = cmp.Or(list.Title, tag[1])
+			case "description":
+				list.Description = tag[1]
+			case "image":
+				list.Image = tag[1]
+			}
+			continue
+		}
+		list.Items = append(list.Items, tag)
+		switch tag[0] {
+		case "p":
+			if pubkey, err := nostr.PubKeyFromHex(tag[1]); err == nil {
+				list.PubKeys = append(list.PubKeys, pubkey)
+			}
+		case "e":
+			if id, err := nostr.IDFromHex(tag[1]); err == nil {
+				list.EventIDs = append(list.EventIDs, id)
+			}
+		case "a":
+			list.Addresses = append(list.Addresses, tag[1])
+		case "t":
+			list.Hashtags = append(list.Hashtags, tag[1])
+		case "word":
+			list.Words = append(list.Words, tag[1])
+		case "relay", "r":
+			if url := nostr.NormalizeURL(tag[1]); url != "" {
+				list.Relays = append(list.Relays, url)
+			}
+		}
+	}
+	return list, nil
+}
+
+// sameItem tells if two tags are the same list item.
+func sameItem(a, b nostr.Tag) bool {
+	return len(a) >= 2 && len(b) >= 2 && a[0] == b[0] && a[1] == b[1]
+}
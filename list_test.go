@@ -0,0 +1,91 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"fiatjaf.com/nostr"
+)
+
+func TestLists(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk := nostr.Generate()
+	alice, bob := nostr.Generate().Public(), nostr.Generate().Public()
+	mutes, err := db.saveSigned(sk, nostr.KindMuteList,
+		nostr.Tag{"p", alice.Hex()},
+		nostr.Tag{"t", "spam"},
+		nostr.Tag{"word", "gm"},
+		nostr.Tag{"x-unknown", "kept"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.saveSigned(sk, nostr.KindCategorizedPeopleList,
+		nostr.Tag{"d", "friends"}, nostr.Tag{"title", "Friends"}, nostr.Tag{"p", bob.Hex()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.saveSigned(sk, nostr.KindCategorizedPeopleList, nostr.Tag{"d", "devs"}); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := db.GetList(nostr.KindMuteList, sk.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(list.PubKeys, []nostr.PubKey{alice}) || !slices.Equal(list.Hashtags, []string{"spam"}) ||
+		!slices.Equal(list.Words, []string{"gm"}) || len(list.Items) != 4 {
+		t.Fatalf("unexpected mute list: %+v", list)
+	}
+	if _, err := db.GetList(nostr.KindPinList, sk.Public()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected: ErrNotFound, actual: %v", err)
+	}
+
+	set, err := db.GetListSet(nostr.KindCategorizedPeopleList, sk.Public(), "friends")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.D != "friends" || set.Title != "Friends" || !slices.Equal(set.PubKeys, []nostr.PubKey{bob}) {
+		t.Fatalf("unexpected follow set: %+v", set)
+	}
+	sets, err := db.ListSets(nostr.KindCategorizedPeopleList, sk.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 2 || sets[0].D != "devs" || sets[1].D != "friends" {
+		t.Fatalf("unexpected follow sets: %+v", sets)
+	}
+
+	evt, err := db.EditList(nostr.KindMuteList, sk.Public(), "",
+		nostr.Tags{{"p", bob.Hex()}, {"t", "spam"}},
+		nostr.Tags{{"p", alice.Hex()}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evt.CreatedAt <= mutes.CreatedAt {
+		t.Fatalf("edited list is not newer: %d <= %d", evt.CreatedAt, mutes.CreatedAt)
+	}
+	expected := nostr.Tags{{"t", "spam"}, {"word", "gm"}, {"x-unknown", "kept"}, {"p", bob.Hex()}}
+	if len(evt.Tags) != len(expected) {
+		t.Fatalf("unexpected tags: %v", evt.Tags)
+	}
+	for i := range expected {
+		if !slices.Equal(evt.Tags[i], expected[i]) {
+			t.Fatalf("unexpected tags: %v", evt.Tags)
+		}
+	}
+
+	evt, err = db.EditList(nostr.KindBookmarkList, sk.Public(), "", nostr.Tags{{"e", mutes.ID.Hex()}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evt.Kind != nostr.KindBookmarkList || len(evt.Tags) != 1 {
+		t.Fatalf("unexpected new list: %+v", evt)
+	}
+}
@@ -0,0 +1,150 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"context"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/aperturerobotics/go-indexeddb/idb"
+	"github.com/hack-pad/safejs"
+)
+
+// MarkMissing records that relays have no event of a kind by a pubkey, with a
+// d tag for the addressable kinds, for ttl. it's forgotten once such an event
+// is saved. the expired records are purged on every call.
+func (b *IndexeddbBackend) MarkMissing(kind nostr.Kind, pubkey nostr.PubKey, d string, ttl time.Duration) error {
+	return b.update(func(ctx context.Context, store *idb.ObjectStore) ([]Change, error) {
+		missing, err := siblingStore(store, storeNameMissing)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		if err := purgeMissing(ctx, missing, now); err != nil {
+			return nil, err
+		}
+		key, err := missingKey(kind, pubkey, d)
+		if err != nil {
+			return nil, err
+		}
+		value, err := safejs.ValueOf(map[string]any{
+			keyExpires: float64(now.Add(ttl).UnixMilli()),
+		})
+		if err != nil {
+			return nil, err
+		}
+		_, err = missing.PutKey(key, value)
+		return nil, err
+	})
+}
+
+// IsMissing tells if an event of a kind by a pubkey, with a d tag for the
+// addressable kinds, is known missing and shouldn't be asked for again yet.
+func (b *IndexeddbBackend) IsMissing(kind nostr.Kind, pubkey nostr.PubKey, d string) (bool, error) {
+	missing := false
+	err := b.view(func(ctx context.Context, store *idb.ObjectStore) error {
+		var err error
+		missing, err = isMissing(ctx, store, kind, pubkey, d, time.Now())
+		return err
+	})
+	return missing, err
+}
+
+// KnownMissing returns which of the pubkeys are known to have no replaceable
+// event of a kind, in a single transaction.
+func (b *IndexeddbBackend) KnownMissing(kind nostr.Kind, pubkeys []nostr.PubKey) (map[nostr.PubKey]bool, error) {
+	known := map[nostr.PubKey]bool{}
+	err := b.view(func(ctx context.Context, store *idb.ObjectStore) error {
+		now := time.Now()
+		for _, pubkey := range pubkeys {
+			missing, err := isMissing(ctx, store, kind, pubkey, "", now)
+			if err != nil {
+				return err
+			}
+			if missing {
+				known[pubkey] = true
+			}
+		}
+		return nil
+	})
+	return known, err
+}
+
+func isMissing(ctx context.Context, store *idb.ObjectStore, kind nostr.Kind, pubkey nostr.PubKey, d string, now time.Time) (bool, error) {
+	missing, err := siblingStore(store, storeNameMissing)
+	if err != nil {
+		return false, err
+	}
+	key, err := missingKey(kind, pubkey, d)
+	if err != nil {
+		return false, err
+	}
+	req, err := missing.Get(key)
+	if err != nil {
+		return false, err
+	}
+	value, err := req.Await(ctx)
+	if err != nil {
+		return false, err
+	}
+	if value.IsUndefined() || value.IsNull() {
+		return false, nil
+	}
+	ex, err := value.Get(keyExpires)
+	if err != nil {
+		return false, err
+	}
+	expires, err := ex.Float()
+	if err != nil {
+		return false, err
+	}
+	return int64(expires) > now.UnixMilli(), nil
+}
+
+// forgetMissing drops the missing record an event answers.
+func forgetMissing(store *idb.ObjectStore, evt nostr.Event) error {
+	missing, err := siblingStore(store, storeNameMissing)
+	if err != nil {
+		return err
+	}
+	key, err := missingKey(evt.Kind, evt.PubKey, evt.Tags.GetD())
+	if err != nil {
+		return err
+	}
+	_, err = missing.Delete(key)
+	return err
+}
+
+// purgeMissing deletes the expired missing records.
+func purgeMissing(ctx context.Context, missing *idb.ObjectStore, now time.Time) error {
+	idx, err := missing.Index(idxExpires)
+	if err != nil {
+		return err
+	}
+	upper, err := safejs.ValueOf(float64(now.UnixMilli()))
+	if err != nil {
+		return err
+	}
+	rb, err := idb.NewKeyRangeUpperBound(upper, false)
+	if err != nil {
+		return err
+	}
+	req, err := idx.OpenCursorRange(rb, idb.CursorNext)
+	if err != nil {
+		return err
+	}
+	return req.Iter(ctx, func(cursor *idb.CursorWithValue) error {
+		_, err := cursor.Delete()
+		return err
+	})
+}
+
+// missingKey is the key of a missing record, d only counts for the
+// addressable kinds.
+func missingKey(kind nostr.Kind, pubkey nostr.PubKey, d string) (safejs.Value, error) {
+	if !kind.IsAddressable() {
+		d = ""
+	}
+	return safejs.ValueOf([]any{kind.Num(), pubkey.Hex(), d})
+}
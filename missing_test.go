@@ -0,0 +1,67 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"testing"
+	"time"
+
+	"fiatjaf.com/nostr"
+)
+
+func TestMissing(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk1 := nostr.Generate()
+	sk2 := nostr.Generate()
+
+	if err := db.MarkMissing(nostr.KindFollowList, sk1.Public(), "", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.MarkMissing(nostr.KindFollowList, sk2.Public(), "", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.MarkMissing(nostr.KindMuteSets, sk1.Public(), "spam", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	known, err := db.KnownMissing(nostr.KindFollowList, []nostr.PubKey{sk1.Public(), sk2.Public()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(known) != 1 || !known[sk1.Public()] {
+		t.Fatalf("expected only %s to be missing, actual: %v", sk1.Public(), known)
+	}
+	for _, c := range []struct {
+		kind     nostr.Kind
+		d        string
+		expected bool
+	}{
+		{nostr.KindMuteSets, "spam", true},
+		{nostr.KindMuteSets, "other", false},
+		{nostr.KindRelayListMetadata, "", false},
+	} {
+		missing, err := db.IsMissing(c.kind, sk1.Public(), c.d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if missing != c.expected {
+			t.Fatalf("kind %d %q: expected: %v, actual: %v", c.kind, c.d, c.expected, missing)
+		}
+	}
+
+	// saving the event answers the lookup
+	if _, err := db.saveSigned(sk1, nostr.KindFollowList); err != nil {
+		t.Fatal(err)
+	}
+	missing, err := db.IsMissing(nostr.KindFollowList, sk1.Public(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing {
+		t.Fatal("expected the saved profile not to be missing")
+	}
+}
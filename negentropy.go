@@ -0,0 +1,118 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"context"
+	"fmt"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip77/negentropy"
+	"fiatjaf.com/nostr/nip77/negentropy/storage/vector"
+	"github.com/aperturerobotics/go-indexeddb/idb"
+	"github.com/hack-pad/safejs"
+)
+
+// NegentropyVector returns the (created_at, id) items of the stored events
+// matching the filter, sealed for NIP-77 reconciliation. a filter with no
+// fields but since and until is read from the created_at index alone,
+// without decoding the events.
+func (b *IndexeddbBackend) NegentropyVector(filter nostr.Filter) (*vector.Vector, error) {
+	vec := vector.New()
+	if !onlyTimeBounds(filter) {
+		if err := validateFilter(filter); err != nil {
+			return nil, err
+		}
+		for evt := range b.QueryEvents(filter, 0) {
+			if filter.Matches(evt) {
+				vec.Insert(evt.CreatedAt, evt.ID)
+			}
+		}
+		vec.Seal()
+		return vec, nil
+	}
+
+	err := b.view(func(ctx context.Context, store *idb.ObjectStore) error {
+		idx, err := store.Index(idxCreatedAt)
+		if err != nil {
+			return err
+		}
+		var req *idb.CursorRequest
+		if filter.Since == 0 && filter.Until == 0 {
+			req, err = idx.OpenKeyCursor(idb.CursorNext)
+		} else {
+			var rb *idb.KeyRange
+			if rb, err = timeRange(filter.Since, filter.Until); err == nil {
+				req, err = idx.OpenKeyCursorRange(rb, idb.CursorNext)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		return req.Iter(ctx, func(cursor *idb.Cursor) error {
+			key, err := cursor.Key()
+			if err != nil {
+				return err
+			}
+			ca, err := key.Int()
+			if err != nil {
+				return err
+			}
+			rawID, err := cursor.PrimaryKey()
+			if err != nil {
+				return err
+			}
+			id_, err := rawID.String()
+			if err != nil {
+				return err
+			}
+			id, err := nostr.IDFromHex(id_)
+			if err != nil {
+				return err
+			}
+			vec.Insert(nostr.Timestamp(ca), id)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	vec.Seal()
+	return vec, nil
+}
+
+// Negentropy starts a NIP-77 reconciliation over the stored events matching
+// the filter. the events we lack come out of HaveNots and those the other
+// side lacks out of Haves, which must be drained while reconciling.
+func (b *IndexeddbBackend) Negentropy(filter nostr.Filter, frameSizeLimit int) (*negentropy.Negentropy, error) {
+	if frameSizeLimit != 0 && frameSizeLimit < 4096 {
+		return nil, fmt.Errorf("frame size limit can't be smaller than 4096, was %d", frameSizeLimit)
+	}
+	vec, err := b.NegentropyVector(filter)
+	if err != nil {
+		return nil, err
+	}
+	return negentropy.New(vec, frameSizeLimit), nil
+}
+
+// onlyTimeBounds tells if a filter selects events by created_at only.
+func onlyTimeBounds(filter nostr.Filter) bool {
+	return len(filter.IDs) == 0 && len(filter.Kinds) == 0 && len(filter.Authors) == 0 &&
+		len(filter.Tags) == 0 && filter.Search == ""
+}
+
+// timeRange is the created_at range of since and until, 0 being unbounded.
+func timeRange(since, until nostr.Timestamp) (*idb.KeyRange, error) {
+	lower, err := safejs.ValueOf(int64(since))
+	if err != nil {
+		return nil, err
+	}
+	if until == 0 {
+		return idb.NewKeyRangeLowerBound(lower, false)
+	}
+	upper, err := safejs.ValueOf(int64(until))
+	if err != nil {
+		return nil, err
+	}
+	return idb.NewKeyRangeBound(lower, upper, false, false)
+}
@@ -0,0 +1,94 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"slices"
+	"sync"
+	"testing"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip77/negentropy"
+	"fiatjaf.com/nostr/nip77/negentropy/storage/vector"
+)
+
+func TestNegentropy(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the relay has the first 3 profiles, we have the last 3
+	events := []nostr.Event{}
+	for range 4 {
+		evt := nostr.Event{Kind: nostr.KindProfileMetadata, CreatedAt: nostr.Now(), Content: "{}", Tags: nostr.Tags{}}
+		if err := evt.Sign(nostr.Generate()); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, evt)
+	}
+	for _, evt := range events[1:] {
+		if err := db.SaveEvent(evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	peerVec := vector.New()
+	for _, evt := range events[:3] {
+		peerVec.Insert(evt.CreatedAt, evt.ID)
+	}
+	peerVec.Seal()
+
+	all, err := db.NegentropyVector(nostr.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all.Size() != 3 {
+		t.Fatalf("expected: 3 items, actual: %d", all.Size())
+	}
+	none, err := db.NegentropyVector(nostr.Filter{Until: events[0].CreatedAt - 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if none.Size() != 0 {
+		t.Fatalf("expected: 0 items, actual: %d", none.Size())
+	}
+
+	neg, err := db.Negentropy(nostr.Filter{Kinds: []nostr.Kind{nostr.KindProfileMetadata}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var haves, haveNots []nostr.ID
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for id := range neg.Haves {
+			haves = append(haves, id)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for id := range neg.HaveNots {
+			haveNots = append(haveNots, id)
+		}
+	}()
+
+	peer := negentropy.New(peerVec, 0)
+	msg := neg.Start()
+	for msg != "" {
+		reply, err := peer.Reconcile(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg, err = neg.Reconcile(reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	if !slices.Equal(haves, []nostr.ID{events[3].ID}) {
+		t.Fatalf("expected to have: %s, actual: %v", events[3].ID, haves)
+	}
+	if !slices.Equal(haveNots, []nostr.ID{events[0].ID}) {
+		t.Fatalf("expected to lack: %s, actual: %v", events[0].ID, haveNots)
+	}
+}
@@ -0,0 +1,255 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/aperturerobotics/go-indexeddb/idb"
+	"github.com/hack-pad/safejs"
+)
+
+// OplogConfig is the retention of the oplog, the oldest entries past any of
+// the limits are dropped on every write. zero limits keep everything.
+type OplogConfig struct {
+	MaxAge     time.Duration
+	MaxEntries int
+}
+
+// OplogEntry is a committed change of the store.
+type OplogEntry struct {
+	// Seq orders the entries. it's drawn from the counter of the ExportSince
+	// checkpoints, after the writes of the change itself, so the entries
+	// after a checkpoint are the changes made after it.
+	Seq    uint64
+	Op     ChangeOp
+	ID     nostr.ID
+	Kind   nostr.Kind
+	PubKey nostr.PubKey
+	// At is the local time of the change.
+	At time.Time
+	// Removed are the events deleted or replaced by the change, which can be
+	// saved again to undo it.
+	Removed []nostr.Event
+}
+
+// OplogEntries returns up to limit entries of the oplog after the sequence,
+// oldest first, 0 returning all of them. it's empty unless Oplog is set.
+func (b *IndexeddbBackend) OplogEntries(after uint64, limit int) ([]OplogEntry, error) {
+	entries := []OplogEntry{}
+	err := b.view(func(ctx context.Context, store *idb.ObjectStore) error {
+		oplog, err := siblingStore(store, storeNameOplog)
+		if err != nil {
+			return err
+		}
+		lower, err := safejs.ValueOf(float64(after))
+		if err != nil {
+			return err
+		}
+		rb, err := idb.NewKeyRangeLowerBound(lower, true)
+		if err != nil {
+			return err
+		}
+		req, err := oplog.OpenCursorRange(rb, idb.CursorNext)
+		if err != nil {
+			return err
+		}
+		return req.Iter(ctx, func(cursor *idb.CursorWithValue) error {
+			key, err := cursor.PrimaryKey()
+			if err != nil {
+				return err
+			}
+			value, err := cursor.Value()
+			if err != nil {
+				return err
+			}
+			entry, err := valueToOplogEntry(key, value)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+			if len(entries) == limit {
+				return idb.ErrCursorStopIter
+			}
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// writeOplog records changes in the oplog, then drops the entries past the
+// retention.
+func writeOplog(ctx context.Context, store *idb.ObjectStore, config OplogConfig, changes []Change) error {
+	oplog, err := siblingStore(store, storeNameOplog)
+	if err != nil {
+		return err
+	}
+	at := time.Now()
+	for _, change := range changes {
+		removed := []any{}
+		if change.Op == ChangeDelete {
+			removed = append(removed, change.Event.String())
+		}
+		for _, evt := range change.Replaced {
+			removed = append(removed, evt.String())
+		}
+		seq, err := nextSeq(ctx, store)
+		if err != nil {
+			return err
+		}
+		key, err := safejs.ValueOf(seq)
+		if err != nil {
+			return err
+		}
+		value, err := safejs.ValueOf(map[string]any{
+			keyOp:      change.Op.String(),
+			keyID:      change.Event.ID.Hex(),
+			keyKind:    change.Event.Kind.Num(),
+			keyAuthor:  change.Event.PubKey.Hex(),
+			keyAt:      at.UnixMilli(),
+			keyRemoved: removed,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := oplog.AddKey(key, value); err != nil {
+			return err
+		}
+	}
+	return pruneOplog(ctx, oplog, config, at)
+}
+
+// pruneOplog drops the oldest entries past the retention.
+func pruneOplog(ctx context.Context, oplog *idb.ObjectStore, config OplogConfig, now time.Time) error {
+	excess := 0
+	if config.MaxEntries > 0 {
+		req, err := oplog.Count()
+		if err != nil {
+			return err
+		}
+		count, err := req.Await(ctx)
+		if err != nil {
+			return err
+		}
+		excess = int(count) - config.MaxEntries
+	}
+	if excess <= 0 && config.MaxAge <= 0 {
+		return nil
+	}
+	cutoff := now.Add(-config.MaxAge).UnixMilli()
+
+	req, err := oplog.OpenCursor(idb.CursorNext)
+	if err != nil {
+		return err
+	}
+	return req.Iter(ctx, func(cursor *idb.CursorWithValue) error {
+		value, err := cursor.Value()
+		if err != nil {
+			return err
+		}
+		at_, err := value.Get(keyAt)
+		if err != nil {
+			return err
+		}
+		at, err := at_.Float()
+		if err != nil {
+			return err
+		}
+		if excess <= 0 && (config.MaxAge <= 0 || int64(at) >= cutoff) {
+			return idb.ErrCursorStopIter
+		}
+		excess--
+		_, err = cursor.Delete()
+		return err
+	})
+}
+
+func valueToOplogEntry(rawKey, rawEntry safejs.Value) (OplogEntry, error) {
+	seq, err := rawKey.Float()
+	if err != nil {
+		return OplogEntry{}, err
+	}
+	entry := OplogEntry{Seq: uint64(seq)}
+
+	op_, err := rawEntry.Get(keyOp)
+	if err != nil {
+		return OplogEntry{}, err
+	}
+	op, err := op_.String()
+	if err != nil {
+		return OplogEntry{}, err
+	}
+	for o := ChangeSave; o <= ChangeDelete; o++ {
+		if o.String() == op {
+			entry.Op = o
+		}
+	}
+	id_, err := rawEntry.Get(keyID)
+	if err != nil {
+		return OplogEntry{}, err
+	}
+	id, err := id_.String()
+	if err != nil {
+		return OplogEntry{}, err
+	}
+	if entry.ID, err = nostr.IDFromHex(id); err != nil {
+		return OplogEntry{}, err
+	}
+	k_, err := rawEntry.Get(keyKind)
+	if err != nil {
+		return OplogEntry{}, err
+	}
+	k, err := k_.Int()
+	if err != nil {
+		return OplogEntry{}, err
+	}
+	entry.Kind = nostr.Kind(k)
+	a_, err := rawEntry.Get(keyAuthor)
+	if err != nil {
+		return OplogEntry{}, err
+	}
+	a, err := a_.String()
+	if err != nil {
+		return OplogEntry{}, err
+	}
+	if entry.PubKey, err = nostr.PubKeyFromHex(a); err != nil {
+		return OplogEntry{}, err
+	}
+	at_, err := rawEntry.Get(keyAt)
+	if err != nil {
+		return OplogEntry{}, err
+	}
+	at, err := at_.Float()
+	if err != nil {
+		return OplogEntry{}, err
+	}
+	entry.At = time.UnixMilli(int64(at))
+
+	rm_, err := rawEntry.Get(keyRemoved)
+	if err != nil {
+		return OplogEntry{}, err
+	}
+	l, err := rm_.Length()
+	if err != nil {
+		return OplogEntry{}, err
+	}
+	for i := 0; i < l; i++ {
+		raw, err := rm_.Index(i)
+		if err != nil {
+			return OplogEntry{}, err
+		}
+		s, err := raw.String()
+		if err != nil {
+			return OplogEntry{}, err
+		}
+		var evt nostr.Event
+		if err := json.Unmarshal([]byte(s), &evt); err != nil {
+			return OplogEntry{}, err
+		}
+		entry.Removed = append(entry.Removed, evt)
+	}
+	return entry, nil
+}
@@ -0,0 +1,114 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"io"
+	"testing"
+
+	"fiatjaf.com/nostr"
+)
+
+func TestOplog(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Oplog = &OplogConfig{MaxEntries: 3}
+	sk := nostr.Generate()
+
+	follows, err := db.saveSigned(sk, nostr.KindFollowList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer := nostr.Event{Kind: nostr.KindFollowList, CreatedAt: follows.CreatedAt + 1, Tags: nostr.Tags{}}
+	if err := newer.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ReplaceEvent(newer); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteEvent(newer.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := db.OplogEntries(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected: 3 entries, actual: %+v", entries)
+	}
+	for i, expected := range []struct {
+		op      ChangeOp
+		id      nostr.ID
+		removed []nostr.ID
+	}{
+		{ChangeSave, follows.ID, nil},
+		{ChangeReplace, newer.ID, []nostr.ID{follows.ID}},
+		{ChangeDelete, newer.ID, []nostr.ID{newer.ID}},
+	} {
+		entry := entries[i]
+		if entry.Op != expected.op || entry.ID != expected.id || entry.PubKey != sk.Public() ||
+			entry.Kind != nostr.KindFollowList || len(entry.Removed) != len(expected.removed) || entry.At.IsZero() {
+			t.Fatalf("unexpected entry %d: %+v", i, entry)
+		}
+		for j, id := range expected.removed {
+			if entry.Removed[j].ID != id || !entry.Removed[j].VerifySignature() {
+				t.Fatalf("unexpected removed event of entry %d: %+v", i, entry.Removed[j])
+			}
+		}
+		if i > 0 && entry.Seq <= entries[i-1].Seq {
+			t.Fatalf("entries out of order: %+v", entries)
+		}
+	}
+
+	// undo the delete
+	if err := db.ReplaceEvent(entries[2].Removed[0]); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = db.OplogEntries(entries[2].Seq, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Op != ChangeSave || entries[0].ID != newer.ID {
+		t.Fatalf("unexpected entries after undo: %+v", entries)
+	}
+
+	// the first entry is past the retention now
+	entries, err = db.OplogEntries(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].Op != ChangeReplace {
+		t.Fatalf("unexpected entries after pruning: %+v", entries)
+	}
+}
+
+func TestOplogCheckpoint(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Oplog = &OplogConfig{}
+	if _, err := db.saveSigned(nostr.Generate(), nostr.KindFollowList); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err := db.ExportSince(io.Discard, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later, err := db.saveSigned(nostr.Generate(), nostr.KindMuteList)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the entries after a checkpoint are the changes made after it
+	entries, err := db.OplogEntries(checkpoint, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ID != later.ID {
+		t.Fatalf("expected: the entry of %s, actual: %+v", later.ID, entries)
+	}
+}
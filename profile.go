@@ -0,0 +1,71 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"fiatjaf.com/nostr"
+)
+
+// Profile is a parsed kind 0 event, with the same fields as sdk.ProfileMetadata.
+type Profile struct {
+	PubKey    nostr.PubKey    `json:"-"`
+	CreatedAt nostr.Timestamp `json:"-"`
+	Event     nostr.Event     `json:"-"`
+
+	Name        string `json:"name,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	About       string `json:"about,omitempty"`
+	Website     string `json:"website,omitempty"`
+	Picture     string `json:"picture,omitempty"`
+	Banner      string `json:"banner,omitempty"`
+	NIP05       string `json:"nip05,omitempty"`
+	LUD16       string `json:"lud16,omitempty"`
+}
+
+// GetProfile returns the stored profile of a pubkey, or ErrNotFound.
+func (b *IndexeddbBackend) GetProfile(pubkey nostr.PubKey) (Profile, error) {
+	profiles, err := b.GetProfiles([]nostr.PubKey{pubkey})
+	if err != nil {
+		return Profile{}, err
+	}
+	profile, ok := profiles[pubkey]
+	if !ok {
+		return Profile{}, ErrNotFound
+	}
+	return profile, nil
+}
+
+// GetProfiles returns the stored profiles of the pubkeys that have one, read
+// in a single transaction. profiles that fail to parse are left out.
+func (b *IndexeddbBackend) GetProfiles(pubkeys []nostr.PubKey) (map[nostr.PubKey]Profile, error) {
+	events, err := b.getReplaceables(nostr.KindProfileMetadata, pubkeys)
+	if err != nil {
+		return nil, err
+	}
+	profiles := make(map[nostr.PubKey]Profile, len(events))
+	for pubkey, evt := range events {
+		profile, err := ParseProfile(evt)
+		if err != nil {
+			logErr(err)
+			continue
+		}
+		profiles[pubkey] = profile
+	}
+	return profiles, nil
+}
+
+func ParseProfile(event nostr.Event) (profile Profile, err error) {
+	if event.Kind != nostr.KindProfileMetadata {
+		return Profile{}, fmt.Errorf("event %s is kind %d, not 0", event.ID, event.Kind)
+	}
+	if err := json.Unmarshal([]byte(event.Content), &profile); err != nil {
+		return Profile{}, fmt.Errorf("failed to parse profile of event %s: %w", event.ID, err)
+	}
+	profile.PubKey = event.PubKey
+	profile.CreatedAt = event.CreatedAt
+	profile.Event = event
+	return profile, nil
+}
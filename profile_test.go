@@ -0,0 +1,52 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"errors"
+	"testing"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/sdk"
+)
+
+func TestProfiles(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pk, err := db.saveProfile(sdk.ProfileMetadata{
+		Name:  "jack",
+		About: "sup",
+		NIP05: "jack@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pk2, err := db.saveProfile(sdk.ProfileMetadata{Name: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jack := nostr.MustPubKeyFromHex(pk)
+	bob := nostr.MustPubKeyFromHex(pk2)
+
+	profile, err := db.GetProfile(jack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Name != "jack" || profile.NIP05 != "jack@example.com" || profile.PubKey != jack || profile.CreatedAt == 0 {
+		t.Fatalf("unexpected profile: %+v", profile)
+	}
+
+	if _, err := db.GetProfile(nostr.Generate().Public()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected: ErrNotFound, actual: %v", err)
+	}
+
+	profiles, err := db.GetProfiles([]nostr.PubKey{jack, bob, nostr.Generate().Public()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 2 || profiles[bob].Name != "bob" {
+		t.Fatalf("unexpected profiles: %+v", profiles)
+	}
+}
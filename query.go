@@ -9,7 +9,6 @@ import (
 	"iter"
 	"slices"
 	"strconv"
-	"strings"
 
 	"fiatjaf.com/nostr"
 	"github.com/aperturerobotics/go-indexeddb/idb"
@@ -18,12 +17,30 @@ import (
 
 func (b *IndexeddbBackend) QueryEvents(filter nostr.Filter, maxLimit int) iter.Seq[nostr.Event] {
 	ctx := context.Background()
-	return func(yield func(nostr.Event) bool) {
-		tx, err := b.db.Transaction(idb.TransactionReadOnly, storeNameEvents)
-		if err != nil {
-			if err := tx.Abort(); err != nil {
-				logErr(err)
+	return func(yield_ func(nostr.Event) bool) {
+		// once the caller stops, the remaining cursors must not call it again.
+		// Close doesn't wait for the caller, it ends the iteration instead
+		stopped := false
+		yield := func(evt nostr.Event) bool {
+			if stopped {
+				return false
 			}
+			b.park()
+			stopped = !yield_(evt)
+			if !b.unpark() {
+				stopped = true
+			}
+			return !stopped
+		}
+
+		if err := b.acquire(); err != nil {
+			logErr(err)
+			return
+		}
+		defer b.release()
+		tx, err := b.transaction(idb.TransactionReadOnly)
+		if err != nil {
+			logErr(err)
 			return
 		}
 		store, err := tx.ObjectStore(storeNameEvents)
@@ -40,33 +57,26 @@ func (b *IndexeddbBackend) QueryEvents(filter nostr.Filter, maxLimit int) iter.S
 			return
 		}
 
-		defer func() {
+		if filter.Search != "" && len(filter.IDs) == 0 {
+			limit := searchLimit(filter, maxLimit)
+			results, hits, err := b.search(ctx, store, filter, limit)
+			// the hits are ranked after the transaction, as a nested one would
+			// make it commit before it's awaited
 			if err := tx.Await(ctx); err != nil {
 				logErr(err)
+				return
 			}
-		}()
-
-		if len(filter.IDs) > 0 {
-			for _, id_ := range filter.IDs {
-				id, err := safejs.ValueOf(id_.Hex())
-				if err != nil {
-					logErr(err)
-					return
-				}
-				req, err := store.Get(id)
-				if err != nil {
-					logErr(err)
-					return
-				}
-				rawEvt, err := req.Await(ctx)
-				if err != nil || rawEvt.IsUndefined() || rawEvt.IsNull() {
-					return
-				}
-				evt, err := valueToEvent(id, rawEvt)
-				if err != nil {
+			if err != nil {
+				logErr(err)
+				return
+			}
+			if results == nil {
+				if results, err = b.rank(hits, limit); err != nil {
 					logErr(err)
 					return
 				}
+			}
+			for _, evt := range results {
 				if !yield(evt) {
 					return
 				}
@@ -74,71 +84,37 @@ func (b *IndexeddbBackend) QueryEvents(filter nostr.Filter, maxLimit int) iter.S
 			return
 		}
 
-		if filter.Search != "" {
-			idx, err := store.Index(idxKindMeta)
-			if err != nil {
+		defer func() {
+			if err := tx.Await(ctx); err != nil {
 				logErr(err)
-				return
 			}
-			if slices.Contains(filter.Kinds, nostr.KindProfileMetadata) {
-				search := strings.TrimSpace(filter.Search)
-				lower, err := safejs.ValueOf([]any{nostr.KindProfileMetadata.Num(), search})
-				if err != nil {
-					logErr(err)
-					return
-				}
-				upper, err := safejs.ValueOf([]any{nostr.KindProfileMetadata.Num(), search + "\uffff"})
-				if err != nil {
-					logErr(err)
-					return
-				}
-				rb, err := idb.NewKeyRangeBound(lower, upper, false, false)
-				if err != nil {
-					logErr(err)
-					return
-				}
-				req, err := idx.OpenCursorRange(rb, idb.CursorNext)
-				if err != nil {
-					logErr(err)
-					return
-				}
-				if err := handleRequest(ctx, yield, req); err != nil {
-					logErr(err)
-					return
-				}
-				return
-			} else if slices.Contains(filter.Kinds, nostr.KindRecommendServer) {
-				search := strings.TrimSpace(filter.Search)
-				if !strings.HasPrefix(search, "wss://") && !strings.HasPrefix(search, "ws://") {
-					search = "wss://" + search
-				}
-				lower, err := safejs.ValueOf([]any{nostr.KindRecommendServer.Num(), search})
+		}()
+
+		if len(filter.IDs) > 0 {
+			for _, id_ := range filter.IDs {
+				id, err := safejs.ValueOf(id_.Hex())
 				if err != nil {
 					logErr(err)
 					return
 				}
-				upper, err := safejs.ValueOf([]any{nostr.KindRecommendServer.Num(), search + "\uffff"})
+				req, err := store.Get(id)
 				if err != nil {
 					logErr(err)
 					return
 				}
-				rb, err := idb.NewKeyRangeBound(lower, upper, false, false)
-				if err != nil {
-					logErr(err)
+				rawEvt, err := req.Await(ctx)
+				if err != nil || rawEvt.IsUndefined() || rawEvt.IsNull() {
 					return
 				}
-				req, err := idx.OpenCursorRange(rb, idb.CursorNext)
+				evt, err := valueToEvent(id, rawEvt)
 				if err != nil {
 					logErr(err)
 					return
 				}
-				if err := handleRequest(ctx, yield, req); err != nil {
-					logErr(err)
+				if !yield(evt) {
 					return
 				}
-				return
 			}
-			logErr(fmt.Errorf("unsupported kinds for search: %v", filter.Kinds))
 			return
 		}
 
@@ -153,12 +129,12 @@ func (b *IndexeddbBackend) QueryEvents(filter nostr.Filter, maxLimit int) iter.S
 					for _, tag := range tags {
 						if len(filter.Authors) < 1 {
 							kt := strconv.Itoa(int(kind)) + tagSymbol + tag
-							lower, err := safejs.ValueOf([]any{kt})
+							lower, err := safejs.ValueOf(kt)
 							if err != nil {
 								logErr(err)
 								return
 							}
-							upper, err := safejs.ValueOf([]any{kt + "\uffff"})
+							upper, err := safejs.ValueOf(kt + "\uffff")
 							if err != nil {
 								logErr(err)
 								return
@@ -277,6 +253,236 @@ func (b *IndexeddbBackend) QueryEvents(filter nostr.Filter, maxLimit int) iter.S
 	}
 }
 
+// view runs fn in a read only transaction on the events store, the other
+// stores being reachable through its transaction.
+func (b *IndexeddbBackend) view(fn func(ctx context.Context, store *idb.ObjectStore) error) error {
+	if err := b.acquire(); err != nil {
+		return err
+	}
+	defer b.release()
+	ctx := context.Background()
+	tx, err := b.transaction(idb.TransactionReadOnly)
+	if err != nil {
+		return err
+	}
+	store, err := tx.ObjectStore(storeNameEvents)
+	if err != nil {
+		return err
+	}
+	if err := fn(ctx, store); err != nil {
+		return err
+	}
+	return tx.Await(ctx)
+}
+
+// update runs fn in a read write transaction on the events store, the other
+// stores being reachable through its transaction. the changes fn returns are
+// written to the oplog in the same transaction, then sent out once committed.
+func (b *IndexeddbBackend) update(fn func(ctx context.Context, store *idb.ObjectStore) ([]Change, error)) error {
+	if err := b.acquire(); err != nil {
+		return err
+	}
+	defer b.release()
+	ctx := context.Background()
+	tx, err := b.transaction(idb.TransactionReadWrite)
+	if err != nil {
+		return err
+	}
+	store, err := tx.ObjectStore(storeNameEvents)
+	if err != nil {
+		return err
+	}
+	changes, err := fn(ctx, store)
+	if err == nil && b.Oplog != nil && len(changes) > 0 {
+		err = writeOplog(ctx, store, *b.Oplog, changes)
+	}
+	if err == nil && slices.ContainsFunc(changes, leavesTombstones) {
+		err = pruneTombstones(ctx, store, b.maxTombstones())
+	}
+	if err != nil {
+		if err := tx.Abort(); err != nil {
+			logErr(err)
+		}
+		return err
+	}
+	if err := tx.Await(ctx); err != nil {
+		return err
+	}
+	for _, change := range changes {
+		b.changed(change)
+	}
+	return nil
+}
+
+// getReplaceables reads the replaceable event of a kind of every pubkey that
+// has one, in a single transaction.
+func (b *IndexeddbBackend) getReplaceables(kind nostr.Kind, pubkeys []nostr.PubKey) (map[nostr.PubKey]nostr.Event, error) {
+	events := make(map[nostr.PubKey]nostr.Event, len(pubkeys))
+	err := b.view(func(ctx context.Context, store *idb.ObjectStore) error {
+		idx, err := store.Index(idxKindAuthor)
+		if err != nil {
+			return err
+		}
+		for _, pubkey := range pubkeys {
+			if _, ok := events[pubkey]; ok {
+				continue
+			}
+			req, err := openOnly(idx, []any{kind.Num(), pubkey.Hex()})
+			if err != nil {
+				return err
+			}
+			if err := handleRequest(ctx, func(evt nostr.Event) bool {
+				if previous, ok := events[pubkey]; !ok || isOlder(previous, evt) {
+					events[pubkey] = evt
+				}
+				return true
+			}, req); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return events, err
+}
+
+// collect reads every event of a cursor.
+func collect(ctx context.Context, req *idb.CursorWithValueRequest) ([]nostr.Event, error) {
+	events := []nostr.Event{}
+	err := handleRequest(ctx, func(evt nostr.Event) bool {
+		events = append(events, evt)
+		return true
+	}, req)
+	return events, err
+}
+
+// openOnly opens a cursor over the entries of an index with the given key.
+func openOnly(idx *idb.Index, key any) (*idb.CursorWithValueRequest, error) {
+	only, err := safejs.ValueOf(key)
+	if err != nil {
+		return nil, err
+	}
+	rb, err := idb.NewKeyRangeOnly(only)
+	if err != nil {
+		return nil, err
+	}
+	return idx.OpenCursorRange(rb, idb.CursorNext)
+}
+
+// getEvent reads a single event by id.
+func getEvent(ctx context.Context, store *idb.ObjectStore, id nostr.ID) (nostr.Event, bool, error) {
+	rawID, err := safejs.ValueOf(id.Hex())
+	if err != nil {
+		return nostr.Event{}, false, err
+	}
+	req, err := store.Get(rawID)
+	if err != nil {
+		return nostr.Event{}, false, err
+	}
+	rawEvt, err := req.Await(ctx)
+	if err != nil {
+		return nostr.Event{}, false, err
+	}
+	if rawEvt.IsUndefined() || rawEvt.IsNull() {
+		return nostr.Event{}, false, nil
+	}
+	evt, err := valueToEvent(rawID, rawEvt)
+	if err != nil {
+		return nostr.Event{}, false, err
+	}
+	return evt, true, nil
+}
+
+// queryIDs lists the ids of the events of a query, in order, for the readers
+// taking them in batches with getEvents: a transaction can't wait for them.
+func (b *IndexeddbBackend) queryIDs(filter nostr.Filter, maxLimit int) []nostr.ID {
+	ids := []nostr.ID{}
+	for evt := range b.QueryEvents(filter, maxLimit) {
+		ids = append(ids, evt.ID)
+	}
+	return ids
+}
+
+// getEvents reads events by id in a single transaction, in order, skipping the
+// ones deleted since.
+func (b *IndexeddbBackend) getEvents(ids []nostr.ID) ([]nostr.Event, error) {
+	events := make([]nostr.Event, 0, len(ids))
+	err := b.view(func(ctx context.Context, store *idb.ObjectStore) error {
+		for _, id := range ids {
+			evt, found, err := getEvent(ctx, store, id)
+			if err != nil {
+				return err
+			}
+			if found {
+				events = append(events, evt)
+			}
+		}
+		return nil
+	})
+	return events, err
+}
+
+// authorEvents reads the events of an author, of the given kinds or profiles
+// when there are none.
+func authorEvents(ctx context.Context, store *idb.ObjectStore, kinds []nostr.Kind, author nostr.PubKey) ([]nostr.Event, error) {
+	if len(kinds) == 0 {
+		kinds = []nostr.Kind{nostr.KindProfileMetadata}
+	}
+	idx, err := store.Index(idxKindAuthor)
+	if err != nil {
+		return nil, err
+	}
+	events := []nostr.Event{}
+	for _, kind := range kinds {
+		req, err := openOnly(idx, []any{kind.Num(), author.Hex()})
+		if err != nil {
+			return nil, err
+		}
+		found, err := collect(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, found...)
+	}
+	return events, nil
+}
+
+// addressEvents reads the addressable event a pointer refers to.
+func addressEvents(ctx context.Context, store *idb.ObjectStore, ptr nostr.EntityPointer) ([]nostr.Event, error) {
+	idx, err := store.Index(idxKindTagAuthor)
+	if err != nil {
+		return nil, err
+	}
+	req, err := openOnly(idx, kindTagAuthor(ptr.Kind, "d", ptr.Identifier, ptr.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	return collect(ctx, req)
+}
+
+// openTagPrefix opens a cursor over the kind-tag-author entries of a tag, of
+// any author.
+func openTagPrefix(idx *idb.Index, kind nostr.Kind, tagName, tagValue string) (*idb.CursorWithValueRequest, error) {
+	kt := strconv.Itoa(int(kind)) + tagName + tagValue
+	lower, err := safejs.ValueOf(kt)
+	if err != nil {
+		return nil, err
+	}
+	upper, err := safejs.ValueOf(kt + "\uffff")
+	if err != nil {
+		return nil, err
+	}
+	rb, err := idb.NewKeyRangeBound(lower, upper, false, false)
+	if err != nil {
+		return nil, err
+	}
+	return idx.OpenCursorRange(rb, idb.CursorNext)
+}
+
+// kindTagAuthor is the key of a tag in the kind-tag-author index.
+func kindTagAuthor(kind nostr.Kind, tagName, tagValue string, author nostr.PubKey) string {
+	return strconv.Itoa(int(kind)) + tagName + tagValue + author.Hex()
+}
+
 func handleRequest(ctx context.Context, yield func(nostr.Event) bool, req *idb.CursorWithValueRequest) error {
 	return req.Iter(ctx, func(cursor *idb.CursorWithValue) error {
 		id, err := cursor.PrimaryKey()
@@ -295,7 +501,7 @@ func handleRequest(ctx context.Context, yield func(nostr.Event) bool, req *idb.C
 			return err
 		}
 		if !yield(evt) {
-			return err
+			return idb.ErrCursorStopIter
 		}
 		return nil
 	})
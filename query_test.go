@@ -7,10 +7,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"slices"
 	"strings"
 	"testing"
 
 	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip19"
 	"fiatjaf.com/nostr/sdk"
 )
 
@@ -77,6 +79,42 @@ func (db *DB) saveGroupMeta(id, name string) (string, string, error) {
 	return evt.ID.Hex(), evt.PubKey.Hex(), nil
 }
 
+func (db *DB) saveRelayInfo(url, name string) (string, string, error) {
+	sk := nostr.Generate()
+
+	c, err := json.Marshal(map[string]any{"url": url, "name": name})
+	if err != nil {
+		return "", "", err
+	}
+	evt := nostr.Event{
+		Kind:      nostr.KindRecommendServer,
+		Content:   string(c),
+		CreatedAt: nostr.Now(),
+	}
+	if err := evt.Sign(sk); err != nil {
+		return "", "", err
+	}
+	if err := db.ReplaceEvent(evt); err != nil {
+		return "", "", err
+	}
+	return evt.ID.Hex(), evt.PubKey.Hex(), nil
+}
+
+func (db *DB) saveRelayList(sk nostr.SecretKey, tags ...nostr.Tag) (string, string, error) {
+	evt := nostr.Event{
+		Kind:      nostr.KindRelayListMetadata,
+		CreatedAt: nostr.Now(),
+		Tags:      tags,
+	}
+	if err := evt.Sign(sk); err != nil {
+		return "", "", err
+	}
+	if err := db.ReplaceEvent(evt); err != nil {
+		return "", "", err
+	}
+	return evt.ID.Hex(), evt.PubKey.Hex(), nil
+}
+
 func TestID(t *testing.T) {
 	db, err := newDB()
 	if err != nil {
@@ -171,6 +209,209 @@ func TestSearch(t *testing.T) {
 	}
 }
 
+func TestSearchRank(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"jackson", "jack", "jackie"}
+	pks := map[string]string{}
+	for _, name := range names {
+		_, pk, err := db.saveProfile(sdk.ProfileMetadata{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pks[name] = pk
+	}
+	search := func(limit int) []string {
+		filter := nostr.Filter{
+			Kinds:  []nostr.Kind{0},
+			Search: "Jack",
+			Limit:  limit,
+		}
+		res := []string{}
+		for evt := range db.QueryEvents(filter, 1000) {
+			meta, err := ParseMeta(evt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res = append(res, meta.Name)
+		}
+		return res
+	}
+
+	res := search(2)
+	if len(res) != 2 || res[0] != "jack" || res[1] != "jackie" {
+		t.Fatalf("expected: [jack jackie], actual: %v", res)
+	}
+
+	db.KnownPubKey = func(pubkey nostr.PubKey) bool {
+		return pubkey.Hex() == pks["jackson"]
+	}
+	res = search(0)
+	if len(res) != 3 || res[0] != "jack" || res[1] != "jackson" {
+		t.Fatalf("expected: [jack jackson jackie], actual: %v", res)
+	}
+}
+
+func TestSearchKinds(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.saveGroupMeta("devs", "Nostr Devs"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.saveProfile(sdk.ProfileMetadata{Name: "nostrich"}); err != nil {
+		t.Fatal(err)
+	}
+	article := nostr.Event{
+		Kind:      nostr.KindArticle,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			nostr.Tag{"d", "intro"},
+			nostr.Tag{"title", "Nostr for beginners"},
+		},
+		Content: "# hello",
+	}
+	if err := article.Sign(nostr.Generate()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ReplaceEvent(article); err != nil {
+		t.Fatal(err)
+	}
+
+	filter := nostr.Filter{
+		Kinds:  []nostr.Kind{nostr.KindSimpleGroupMetadata, nostr.KindArticle},
+		Search: "nostr",
+	}
+	kinds := map[nostr.Kind]int{}
+	for evt := range db.QueryEvents(filter, 1000) {
+		kinds[evt.Kind]++
+	}
+	if len(kinds) != 2 || kinds[nostr.KindSimpleGroupMetadata] != 1 || kinds[nostr.KindArticle] != 1 {
+		t.Fatalf("expected one group and one article, actual: %v", kinds)
+	}
+
+	filter = nostr.Filter{Search: "nostr"}
+	count := 0
+	for range db.QueryEvents(filter, 1000) {
+		count++
+	}
+	if count != 3 {
+		t.Fatalf("count expected: 3, actual: %d", count)
+	}
+}
+
+func TestSearchRelays(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.saveRelayInfo("wss://relay.damus.io", "Damus"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.saveRelayList(nostr.Generate(),
+		nostr.Tag{"r", "wss://nos.lol"},
+		nostr.Tag{"r", "ws://abcdefgh.onion", "read"},
+		nostr.Tag{"r", "wss://relay.damus.io/", "write"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	filter := nostr.Filter{
+		Kinds:  []nostr.Kind{nostr.KindRecommendServer},
+		Search: "damus",
+	}
+	count := 0
+	for range db.QueryEvents(filter, 1000) {
+		count++
+	}
+	if count != 1 {
+		t.Fatalf("count expected: 1, actual: %d", count)
+	}
+
+	filter = nostr.Filter{
+		Kinds:  []nostr.Kind{nostr.KindRelayListMetadata},
+		Search: "onion",
+	}
+	count = 0
+	for range db.QueryEvents(filter, 1000) {
+		count++
+	}
+	if count != 1 {
+		t.Fatalf("count expected: 1, actual: %d", count)
+	}
+
+	urls, err := db.SearchRelays("DAMUS", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 || urls[0] != "wss://relay.damus.io" {
+		t.Fatalf("expected: [wss://relay.damus.io], actual: %v", urls)
+	}
+	urls, err = db.SearchRelays("o", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the start of a label ranks above any other substring
+	expected := []string{"ws://abcdefgh.onion", "wss://nos.lol", "wss://relay.damus.io"}
+	if !slices.Equal(urls, expected) {
+		t.Fatalf("expected: %v, actual: %v", expected, urls)
+	}
+
+	filter = nostr.Filter{
+		Kinds:  []nostr.Kind{nostr.KindRecommendServer},
+		Search: "amus",
+	}
+	count = 0
+	for range db.QueryEvents(filter, 1000) {
+		count++
+	}
+	if count != 1 {
+		t.Fatalf("count expected: 1, actual: %d", count)
+	}
+}
+
+func TestSearchEntities(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, pk, err := db.saveProfile(sdk.ProfileMetadata{Name: "jack"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.saveProfile(sdk.ProfileMetadata{Name: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	groupID, groupPK, err := db.saveGroupMeta("devs", "Devs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubkey := nostr.MustPubKeyFromHex(pk)
+	searches := map[string]string{
+		nip19.EncodeNpub(pubkey):            id,
+		"nostr:" + nip19.EncodeNpub(pubkey): id,
+		nip19.EncodeNprofile(pubkey, nil):   id,
+		pk[:12]:                             id,
+		strings.ToUpper(pk):                 id,
+		nip19.EncodeNevent(nostr.MustIDFromHex(id), nil, pubkey):                                        id,
+		nip19.EncodeNaddr(nostr.MustPubKeyFromHex(groupPK), nostr.KindSimpleGroupMetadata, "devs", nil): groupID,
+	}
+	for search, expected := range searches {
+		filter := nostr.Filter{Search: search}
+		ids := []string{}
+		for evt := range db.QueryEvents(filter, 1000) {
+			ids = append(ids, evt.ID.Hex())
+		}
+		if len(ids) != 1 || ids[0] != expected {
+			t.Errorf("search %s expected: [%s], actual: %v", search, expected, ids)
+		}
+	}
+}
+
 func TestKindTagAuthor(t *testing.T) {
 	db, err := newDB()
 
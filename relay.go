@@ -0,0 +1,280 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"syscall/js"
+
+	"fiatjaf.com/nostr"
+)
+
+// RelayConn is a duplex stream of NIP-01 messages, e.g. a PortConn.
+type RelayConn interface {
+	// ReadMessage waits for the next message, failing once the stream is
+	// closed, with io.EOF when it's closed cleanly.
+	ReadMessage() (string, error)
+	WriteMessage(message string) error
+}
+
+// ServeRelay speaks NIP-01 over a conn as if the backend were a relay, so that
+// relay pool code can use the local cache like any other relay:
+//
+//   - REQ sends the stored events matching the filters, EOSE, then the newly
+//     saved ones until CLOSE
+//   - EVENT saves the event, replacing its older versions, and answers OK. the
+//     kinds that aren't stored are refused
+//   - COUNT answers the number of stored events matching the filter
+//
+// it returns once reading fails, nil when the conn was closed cleanly.
+func ServeRelay(b *IndexeddbBackend, conn RelayConn) error {
+	r := &relay{backend: b, conn: conn, subs: map[string]func(){}}
+	defer r.closeAll()
+	for {
+		message, err := conn.ReadMessage()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		r.handle(message)
+	}
+}
+
+type relay struct {
+	backend *IndexeddbBackend
+	conn    RelayConn
+
+	writing sync.Mutex
+	mu      sync.Mutex
+	subs    map[string]func()
+}
+
+func (r *relay) handle(message string) {
+	env, err := nostr.ParseMessage(message)
+	if err != nil {
+		r.write(nostr.NoticeEnvelope("error: " + err.Error()))
+		return
+	}
+	switch env := env.(type) {
+	case *nostr.ReqEnvelope:
+		r.req(env.SubscriptionID, env.Filters)
+	case *nostr.CloseEnvelope:
+		r.close(string(*env))
+	case *nostr.EventEnvelope:
+		r.event(env.Event)
+	case *nostr.CountEnvelope:
+		r.count(env.SubscriptionID, env.Filter)
+	default:
+		r.write(nostr.NoticeEnvelope("error: unsupported " + env.Label()))
+	}
+}
+
+// req subscribes to every filter, EOSE being sent once all the stored events
+// were. an event matching several filters is sent once: the stored ones by
+// their id, the live ones by the first filter they match. an invalid filter
+// closes the subscription.
+func (r *relay) req(id string, filters []nostr.Filter) {
+	r.close(id)
+	for _, filter := range filters {
+		if err := validateFilter(filter); err != nil {
+			r.write(nostr.ClosedEnvelope{SubscriptionID: id, Reason: "invalid: " + err.Error()})
+			return
+		}
+	}
+	if len(filters) == 0 {
+		r.write(nostr.EOSEEnvelope(id))
+		return
+	}
+
+	var mu sync.Mutex
+	pending := len(filters)
+	// the ids are only tracked until EOSE
+	sent := map[nostr.ID]bool{}
+	cancels := make([]func(), 0, len(filters))
+	for i, filter := range filters {
+		changes, cancel, err := r.backend.subscribe(filter)
+		if err != nil {
+			for _, cancel := range cancels {
+				cancel()
+			}
+			r.write(nostr.ClosedEnvelope{SubscriptionID: id, Reason: "error: " + err.Error()})
+			return
+		}
+		cancels = append(cancels, cancel)
+		go func() {
+			for change := range changes {
+				switch change.Op {
+				case ChangeStored, ChangeSave, ChangeReplace:
+					if change.Op != ChangeStored && slices.ContainsFunc(filters[:i], func(f nostr.Filter) bool { return matches(f, change.Event) }) {
+						continue
+					}
+					mu.Lock()
+					dup := sent[change.Event.ID]
+					if sent != nil {
+						sent[change.Event.ID] = true
+					}
+					mu.Unlock()
+					if !dup {
+						r.write(nostr.EventEnvelope{SubscriptionID: &id, Event: change.Event})
+					}
+				case ChangeEOSE:
+					mu.Lock()
+					pending--
+					last := pending == 0
+					if last {
+						sent = nil
+					}
+					mu.Unlock()
+					if last {
+						r.write(nostr.EOSEEnvelope(id))
+					}
+				}
+			}
+		}()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subs[id] = func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+}
+
+func (r *relay) close(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cancel, ok := r.subs[id]; ok {
+		cancel()
+		delete(r.subs, id)
+	}
+}
+
+func (r *relay) closeAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, cancel := range r.subs {
+		cancel()
+		delete(r.subs, id)
+	}
+}
+
+func (r *relay) event(evt nostr.Event) {
+	ok := nostr.OKEnvelope{EventID: evt.ID, OK: true}
+	var err error
+	switch {
+	case !evt.CheckID():
+		ok.Reason = "invalid: event id is computed incorrectly"
+	case !evt.VerifySignature():
+		ok.Reason = "invalid: signature is invalid"
+	case !isStored(evt.Kind):
+		ok.Reason = fmt.Sprintf("blocked: kind %d is not stored", evt.Kind)
+	case evt.Kind.IsReplaceable() || evt.Kind.IsAddressable():
+		err = r.backend.ReplaceEvent(evt)
+	default:
+		err = r.backend.SaveEvent(evt)
+	}
+	if err != nil {
+		ok.Reason = "error: " + err.Error()
+	}
+	ok.OK = ok.Reason == ""
+	r.write(ok)
+}
+
+func (r *relay) count(id string, filter nostr.Filter) {
+	count, err := r.backend.CountEvents(filter)
+	if err != nil {
+		r.write(nostr.ClosedEnvelope{SubscriptionID: id, Reason: "error: " + err.Error()})
+		return
+	}
+	r.write(nostr.CountEnvelope{SubscriptionID: id, Count: &count})
+}
+
+func (r *relay) write(env json.Marshaler) {
+	message, err := env.MarshalJSON()
+	if err != nil {
+		logErr(err)
+		return
+	}
+	r.writing.Lock()
+	defer r.writing.Unlock()
+	if err := r.conn.WriteMessage(string(message)); err != nil {
+		logErr(err)
+	}
+}
+
+// PortConn is a RelayConn over a MessagePort, or anything with postMessage and
+// message events, the messages being strings.
+type PortConn struct {
+	port      js.Value
+	onMessage js.Func
+	messages  *queue[portMessage]
+
+	mu     sync.Mutex
+	closed bool
+}
+
+type portMessage struct {
+	data   string
+	closed bool
+}
+
+// NewPortConn starts listening to a port.
+func NewPortConn(port js.Value) *PortConn {
+	c := &PortConn{port: port, messages: newQueue[portMessage]()}
+	c.onMessage = js.FuncOf(func(this js.Value, args []js.Value) any {
+		data := args[0].Get("data")
+		if data.Type() != js.TypeString {
+			return nil
+		}
+		c.messages.push(portMessage{data: data.String()})
+		return nil
+	})
+	port.Call("addEventListener", "message", c.onMessage)
+	if start := port.Get("start"); start.Type() == js.TypeFunction {
+		port.Call("start")
+	}
+	return c
+}
+
+func (c *PortConn) ReadMessage() (string, error) {
+	m := c.messages.next()
+	if m.closed {
+		// later reads fail too
+		c.messages.push(m)
+		return "", io.EOF
+	}
+	return m.data, nil
+}
+
+func (c *PortConn) WriteMessage(message string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	c.port.Call("postMessage", message)
+	return nil
+}
+
+// Close stops listening to the port, the messages already received are still
+// read before io.EOF.
+func (c *PortConn) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.port.Call("removeEventListener", "message", c.onMessage)
+	c.onMessage.Release()
+	c.messages.push(portMessage{closed: true})
+}
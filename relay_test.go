@@ -0,0 +1,196 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"io"
+	"strings"
+	"syscall/js"
+	"testing"
+	"time"
+
+	"fiatjaf.com/nostr"
+)
+
+// pipe is the relay end of an in-memory conn.
+type pipe struct {
+	in  chan string
+	out chan string
+}
+
+func (p *pipe) ReadMessage() (string, error) {
+	message, ok := <-p.in
+	if !ok {
+		return "", io.EOF
+	}
+	return message, nil
+}
+
+func (p *pipe) WriteMessage(message string) error {
+	p.out <- message
+	return nil
+}
+
+// next reads the next message the relay sent.
+func (p *pipe) next(t *testing.T) nostr.Envelope {
+	t.Helper()
+	select {
+	case message := <-p.out:
+		env, err := nostr.ParseMessage(message)
+		if err != nil {
+			t.Fatalf("%s: %v", message, err)
+		}
+		return env
+	case <-time.After(time.Second):
+		t.Fatal("no message")
+		return nil
+	}
+}
+
+// eventMessage is the EVENT message publishing evt, the envelope has no String
+// of its own.
+func eventMessage(evt nostr.Event) string {
+	message, _ := nostr.EventEnvelope{Event: evt}.MarshalJSON()
+	return string(message)
+}
+
+func TestRelay(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &pipe{in: make(chan string), out: make(chan string, 10)}
+	served := make(chan error)
+	go func() { served <- ServeRelay(db.IndexeddbBackend, conn) }()
+
+	sk := nostr.Generate()
+	follows, err := db.saveSigned(sk, nostr.KindFollowList)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.in <- `["REQ","sub",{"kinds":[3]},{"authors":["` + sk.Public().Hex() + `"]}]`
+	if env, ok := conn.next(t).(*nostr.EventEnvelope); !ok || *env.SubscriptionID != "sub" || env.Event.ID != follows.ID {
+		t.Fatalf("expected the stored event once, actual: %v", env)
+	}
+	if env, ok := conn.next(t).(*nostr.EOSEEnvelope); !ok || string(*env) != "sub" {
+		t.Fatalf("expected: EOSE, actual: %v", env)
+	}
+
+	newer := nostr.Event{Kind: nostr.KindFollowList, CreatedAt: follows.CreatedAt + 1, Tags: nostr.Tags{}}
+	if err := newer.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	conn.in <- eventMessage(newer)
+	// the OK and the live event may come in any order
+	for range 2 {
+		switch env := conn.next(t).(type) {
+		case *nostr.OKEnvelope:
+			if !env.OK || env.EventID != newer.ID {
+				t.Fatalf("unexpected OK: %v", env)
+			}
+		case *nostr.EventEnvelope:
+			if env.Event.ID != newer.ID {
+				t.Fatalf("unexpected live event: %v", env)
+			}
+		default:
+			t.Fatalf("unexpected message: %v", env)
+		}
+	}
+
+	note := nostr.Event{Kind: nostr.KindTextNote, CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	if err := note.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	conn.in <- eventMessage(note)
+	if env, ok := conn.next(t).(*nostr.OKEnvelope); !ok || env.OK || env.Reason == "" {
+		t.Fatalf("expected the note to be refused, actual: %v", env)
+	}
+	note.Content = "tampered"
+	conn.in <- eventMessage(note)
+	if env, ok := conn.next(t).(*nostr.OKEnvelope); !ok || env.OK {
+		t.Fatalf("expected an invalid event to be refused, actual: %v", env)
+	}
+
+	conn.in <- `["COUNT","count",{"kinds":[3],"authors":["` + sk.Public().Hex() + `"]}]`
+	if env, ok := conn.next(t).(*nostr.CountEnvelope); !ok || env.SubscriptionID != "count" || env.Count == nil || *env.Count != 1 {
+		t.Fatalf("expected: 1, actual: %v", env)
+	}
+
+	conn.in <- `["CLOSE","sub"]`
+	if _, err := db.saveSigned(nostr.Generate(), nostr.KindFollowList); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case message := <-conn.out:
+		t.Fatalf("unexpected message after CLOSE: %s", message)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(conn.in)
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRelayClosed(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &pipe{in: make(chan string), out: make(chan string, 10)}
+	served := make(chan error)
+	go func() { served <- ServeRelay(db.IndexeddbBackend, conn) }()
+
+	conn.in <- `["REQ","sub",{"kinds":[3]},{"since":1}]`
+	if env, ok := conn.next(t).(*nostr.ClosedEnvelope); !ok || env.SubscriptionID != "sub" || !strings.HasPrefix(env.Reason, "invalid: ") {
+		t.Fatalf("expected the invalid filter to close the subscription, actual: %v", env)
+	}
+
+	db.Close()
+	conn.in <- `["REQ","sub",{"kinds":[3]}]`
+	if env, ok := conn.next(t).(*nostr.ClosedEnvelope); !ok || env.SubscriptionID != "sub" || !strings.HasPrefix(env.Reason, "error: ") {
+		t.Fatalf("expected the closed backend to close the subscription, actual: %v", env)
+	}
+
+	close(conn.in)
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRelayPort(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipe := js.Global().Get("MessageChannel").New()
+	conn := NewPortConn(pipe.Get("port1"))
+	served := make(chan error)
+	go func() { served <- ServeRelay(db.IndexeddbBackend, conn) }()
+
+	replies := make(chan string, 1)
+	onMessage := js.FuncOf(func(this js.Value, args []js.Value) any {
+		replies <- args[0].Get("data").String()
+		return nil
+	})
+	defer onMessage.Release()
+	client := pipe.Get("port2")
+	client.Call("addEventListener", "message", onMessage)
+	client.Call("start")
+
+	client.Call("postMessage", `["REQ","sub",{"kinds":[0]}]`)
+	select {
+	case reply := <-replies:
+		if reply != `["EOSE","sub"]` {
+			t.Fatalf("expected: EOSE, actual: %s", reply)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reply")
+	}
+
+	conn.Close()
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,215 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip11"
+	"github.com/aperturerobotics/go-indexeddb/idb"
+	"github.com/hack-pad/safejs"
+)
+
+// RelayInfo is a NIP-11 relay information document stored as a kind 2 event.
+type RelayInfo struct {
+	nip11.RelayInformationDocument
+	CreatedAt nostr.Timestamp
+	Event     nostr.Event
+}
+
+// SupportsNIP tells if the relay lists the nip as supported.
+func (info RelayInfo) SupportsNIP(nip int) bool {
+	return slices.Contains(supportedNIPs(info.RelayInformationDocument), nip)
+}
+
+// RelayQuery selects relay infos by capability, every set field must match.
+type RelayQuery struct {
+	NIPs             []int
+	AuthRequired     *bool
+	PaymentRequired  *bool
+	RestrictedWrites *bool
+}
+
+func (q RelayQuery) caps() []string {
+	caps := []string{}
+	for _, nip := range q.NIPs {
+		caps = append(caps, capNIP(nip))
+	}
+	if q.AuthRequired != nil {
+		caps = append(caps, capFlag("auth_required", *q.AuthRequired))
+	}
+	if q.PaymentRequired != nil {
+		caps = append(caps, capFlag("payment_required", *q.PaymentRequired))
+	}
+	if q.RestrictedWrites != nil {
+		caps = append(caps, capFlag("restricted_writes", *q.RestrictedWrites))
+	}
+	return caps
+}
+
+// GetRelayInfo returns the newest stored info of a relay, or ErrNotFound.
+func (b *IndexeddbBackend) GetRelayInfo(url string) (RelayInfo, error) {
+	url = nostr.NormalizeURL(url)
+	only, err := safejs.ValueOf([]any{nostr.KindRecommendServer.Num(), url})
+	if err != nil {
+		return RelayInfo{}, err
+	}
+	rb, err := idb.NewKeyRangeOnly(only)
+	if err != nil {
+		return RelayInfo{}, err
+	}
+	infos, err := b.queryRelayInfos(idxKindMeta, rb, nil)
+	if err != nil {
+		return RelayInfo{}, err
+	}
+	if len(infos) == 0 {
+		return RelayInfo{}, ErrNotFound
+	}
+	return infos[0], nil
+}
+
+// QueryRelayInfos returns the newest stored info of every relay matching the
+// query, e.g. those supporting NIP-50 or those not requiring auth.
+func (b *IndexeddbBackend) QueryRelayInfos(q RelayQuery) ([]RelayInfo, error) {
+	caps := q.caps()
+	if len(caps) == 0 {
+		lower, err := safejs.ValueOf([]any{nostr.KindRecommendServer.Num()})
+		if err != nil {
+			return nil, err
+		}
+		upper, err := safejs.ValueOf([]any{nostr.KindRecommendServer.Num(), "\uffff"})
+		if err != nil {
+			return nil, err
+		}
+		rb, err := idb.NewKeyRangeBound(lower, upper, false, false)
+		if err != nil {
+			return nil, err
+		}
+		return b.queryRelayInfos(idxKindAuthor, rb, nil)
+	}
+
+	only, err := safejs.ValueOf(caps[0])
+	if err != nil {
+		return nil, err
+	}
+	rb, err := idb.NewKeyRangeOnly(only)
+	if err != nil {
+		return nil, err
+	}
+	return b.queryRelayInfos(idxRelayCaps, rb, caps[1:])
+}
+
+// queryRelayInfos reads the relay infos in a range of an index having all the
+// other caps, keeping the newest one of each relay.
+func (b *IndexeddbBackend) queryRelayInfos(index string, rb *idb.KeyRange, caps []string) ([]RelayInfo, error) {
+	infos := []RelayInfo{}
+	byURL := map[string]int{}
+	err := b.view(func(ctx context.Context, store *idb.ObjectStore) error {
+		idx, err := store.Index(index)
+		if err != nil {
+			return err
+		}
+		req, err := idx.OpenCursorRange(rb, idb.CursorNext)
+		if err != nil {
+			return err
+		}
+		return handleRequest(ctx, func(evt nostr.Event) bool {
+			info, err := ParseRelayInfo(evt)
+			if err != nil {
+				logErr(err)
+				return true
+			}
+			have := relayCaps(info)
+			for _, c := range caps {
+				if !slices.Contains(have, c) {
+					return true
+				}
+			}
+			if i, ok := byURL[info.URL]; ok {
+				if isOlder(infos[i].Event, evt) {
+					infos[i] = info
+				}
+				return true
+			}
+			byURL[info.URL] = len(infos)
+			infos = append(infos, info)
+			return true
+		}, req)
+	})
+	return infos, err
+}
+
+// ParseRelayInfo reads the NIP-11 document in the content of a kind 2 event,
+// which also has the relay url.
+func ParseRelayInfo(event nostr.Event) (RelayInfo, error) {
+	if event.Kind != nostr.KindRecommendServer {
+		return RelayInfo{}, fmt.Errorf("event %s is kind %d, not %d", event.ID, event.Kind, nostr.KindRecommendServer)
+	}
+	// pubkey is parsed leniently, relays often have it empty or invalid
+	var doc struct {
+		nip11.RelayInformationDocument
+		URL    string `json:"url"`
+		PubKey string `json:"pubkey"`
+	}
+	if err := json.Unmarshal([]byte(event.Content), &doc); err != nil {
+		return RelayInfo{}, fmt.Errorf("failed to parse relay info of event %s: %w", event.ID, err)
+	}
+	info := RelayInfo{
+		RelayInformationDocument: doc.RelayInformationDocument,
+		CreatedAt:                event.CreatedAt,
+		Event:                    event,
+	}
+	info.URL = nostr.NormalizeURL(doc.URL)
+	if pubkey, err := nostr.PubKeyFromHex(doc.PubKey); err == nil {
+		info.PubKey = pubkey
+	}
+	return info, nil
+}
+
+// relayCaps lists the capabilities a relay info is indexed by.
+func relayCaps(info RelayInfo) []string {
+	caps := []string{}
+	for _, nip := range supportedNIPs(info.RelayInformationDocument) {
+		caps = append(caps, capNIP(nip))
+	}
+	limitation := nip11.RelayLimitationDocument{}
+	if info.Limitation != nil {
+		limitation = *info.Limitation
+	}
+	return append(caps,
+		capFlag("auth_required", limitation.AuthRequired),
+		capFlag("payment_required", limitation.PaymentRequired),
+		capFlag("restricted_writes", limitation.RestrictedWrites),
+	)
+}
+
+// supportedNIPs reads supported_nips, which relays fill with numbers or strings.
+func supportedNIPs(doc nip11.RelayInformationDocument) []int {
+	nips := []int{}
+	for _, n := range doc.SupportedNIPs {
+		switch v := n.(type) {
+		case float64:
+			nips = append(nips, int(v))
+		case int:
+			nips = append(nips, v)
+		case string:
+			if nip, err := strconv.Atoi(v); err == nil {
+				nips = append(nips, nip)
+			}
+		}
+	}
+	return nips
+}
+
+func capNIP(nip int) string {
+	return "nip:" + strconv.Itoa(nip)
+}
+
+func capFlag(name string, value bool) string {
+	return name + ":" + strconv.FormatBool(value)
+}
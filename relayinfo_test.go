@@ -0,0 +1,99 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	"fiatjaf.com/nostr"
+)
+
+func (db *DB) saveRelayDocument(doc map[string]any) error {
+	c, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	evt := nostr.Event{
+		Kind:      nostr.KindRecommendServer,
+		Content:   string(c),
+		CreatedAt: nostr.Now(),
+	}
+	if err := evt.Sign(nostr.Generate()); err != nil {
+		return err
+	}
+	return db.SaveEvent(evt)
+}
+
+func TestRelayInfo(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := []map[string]any{
+		{
+			"url":            "wss://search.example.com/",
+			"name":           "Search",
+			"pubkey":         "",
+			"supported_nips": []any{1, 11, 50},
+		},
+		{
+			"url":            "wss://paid.example.com",
+			"name":           "Paid",
+			"supported_nips": []any{1, "50"},
+			"limitation": map[string]any{
+				"auth_required":    true,
+				"payment_required": true,
+			},
+			"fees": map[string]any{
+				"admission": []any{map[string]any{"amount": 1000, "unit": "msats"}},
+			},
+		},
+		{
+			"url":            "wss://plain.example.com",
+			"supported_nips": []any{1},
+		},
+	}
+	for _, doc := range docs {
+		if err := db.saveRelayDocument(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := db.GetRelayInfo("paid.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "Paid" || !info.SupportsNIP(50) || info.Limitation == nil || !info.Limitation.AuthRequired ||
+		info.Fees == nil || len(info.Fees.Admission) != 1 {
+		t.Fatalf("unexpected relay info: %+v", info)
+	}
+	if _, err := db.GetRelayInfo("wss://unknown.example.com"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected: ErrNotFound, actual: %v", err)
+	}
+
+	urls := func(q RelayQuery) []string {
+		infos, err := db.QueryRelayInfos(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls := []string{}
+		for _, info := range infos {
+			urls = append(urls, info.URL)
+		}
+		slices.Sort(urls)
+		return urls
+	}
+	no := false
+	if res := urls(RelayQuery{NIPs: []int{50}}); !slices.Equal(res, []string{"wss://paid.example.com", "wss://search.example.com"}) {
+		t.Fatalf("unexpected nip-50 relays: %v", res)
+	}
+	if res := urls(RelayQuery{NIPs: []int{50}, AuthRequired: &no}); !slices.Equal(res, []string{"wss://search.example.com"}) {
+		t.Fatalf("unexpected nip-50 relays without auth: %v", res)
+	}
+	if res := urls(RelayQuery{}); len(res) != 3 {
+		t.Fatalf("expected 3 relays, actual: %v", res)
+	}
+}
@@ -0,0 +1,197 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"fiatjaf.com/nostr"
+)
+
+// RelayList is a parsed NIP-65 kind 10002 event.
+type RelayList struct {
+	PubKey    nostr.PubKey
+	CreatedAt nostr.Timestamp
+	Event     nostr.Event
+
+	Read  []string
+	Write []string
+}
+
+// OutboxCover is a small set of relays that reaches the outboxes of a set of pubkeys.
+type OutboxCover struct {
+	// Relays maps each chosen relay to the pubkeys it was chosen for.
+	Relays map[string][]nostr.PubKey
+	// Order lists the chosen relays, those reaching the most pubkeys first.
+	Order []string
+
+	// Total is the number of distinct pubkeys asked for.
+	Total int
+	// WithRelayList is the number of them with a stored relay list having write relays.
+	WithRelayList int
+	// Covered is the number of them reached by at least one chosen relay.
+	Covered int
+	// Uncovered are the pubkeys no chosen relay reaches, mostly those
+	// without a stored relay list.
+	Uncovered []nostr.PubKey
+}
+
+// GetRelayList returns the stored relay list of a pubkey, or ErrNotFound.
+func (b *IndexeddbBackend) GetRelayList(pubkey nostr.PubKey) (RelayList, error) {
+	lists, err := b.GetRelayLists([]nostr.PubKey{pubkey})
+	if err != nil {
+		return RelayList{}, err
+	}
+	list, ok := lists[pubkey]
+	if !ok {
+		return RelayList{}, ErrNotFound
+	}
+	return list, nil
+}
+
+// GetRelayLists returns the stored relay lists of the pubkeys that have one,
+// read in a single transaction.
+func (b *IndexeddbBackend) GetRelayLists(pubkeys []nostr.PubKey) (map[nostr.PubKey]RelayList, error) {
+	events, err := b.getReplaceables(nostr.KindRelayListMetadata, pubkeys)
+	if err != nil {
+		return nil, err
+	}
+	lists := make(map[nostr.PubKey]RelayList, len(events))
+	for pubkey, evt := range events {
+		list, err := ParseRelayList(evt)
+		if err != nil {
+			logErr(err)
+			continue
+		}
+		lists[pubkey] = list
+	}
+	return lists, nil
+}
+
+// OutboxRelays picks, from the stored relay lists, a minimal set of write
+// relays so that every pubkey is reached through `redundancy` of its relays
+// (or all of them when it has fewer). maxRelays, when not 0, stops the
+// selection early, leaving the least reached pubkeys uncovered.
+func (b *IndexeddbBackend) OutboxRelays(pubkeys []nostr.PubKey, redundancy, maxRelays int) (OutboxCover, error) {
+	lists, err := b.GetRelayLists(pubkeys)
+	if err != nil {
+		return OutboxCover{}, err
+	}
+	writes := make(map[nostr.PubKey][]string, len(lists))
+	for pubkey, list := range lists {
+		writes[pubkey] = list.Write
+	}
+	return coverOutboxes(pubkeys, writes, redundancy, maxRelays), nil
+}
+
+// coverOutboxes is a greedy set cover: it keeps choosing the relay that
+// reaches the most pubkeys still needing one, the most popular one on ties.
+func coverOutboxes(pubkeys []nostr.PubKey, writes map[nostr.PubKey][]string, redundancy, maxRelays int) OutboxCover {
+	redundancy = max(redundancy, 1)
+	cover := OutboxCover{Relays: map[string][]nostr.PubKey{}}
+
+	need := map[nostr.PubKey]int{}
+	users := map[string][]nostr.PubKey{}
+	for _, pubkey := range pubkeys {
+		if _, ok := need[pubkey]; ok {
+			continue
+		}
+		cover.Total++
+		relays := writes[pubkey]
+		if len(relays) == 0 {
+			need[pubkey] = 0
+			continue
+		}
+		cover.WithRelayList++
+		need[pubkey] = min(redundancy, len(relays))
+		for _, relay := range relays {
+			users[relay] = append(users[relay], pubkey)
+		}
+	}
+
+	for {
+		best, bestGain := "", 0
+		for relay, pubkeys := range users {
+			if _, chosen := cover.Relays[relay]; chosen {
+				continue
+			}
+			gain := 0
+			for _, pubkey := range pubkeys {
+				if need[pubkey] > 0 {
+					gain++
+				}
+			}
+			if gain == 0 {
+				continue
+			}
+			if c := cmp.Or(
+				cmp.Compare(gain, bestGain),
+				cmp.Compare(len(pubkeys), len(users[best])),
+				strings.Compare(best, relay),
+			); c > 0 {
+				best, bestGain = relay, gain
+			}
+		}
+		if best == "" {
+			break
+		}
+		chosenFor := []nostr.PubKey{}
+		for _, pubkey := range users[best] {
+			if need[pubkey] > 0 {
+				need[pubkey]--
+				chosenFor = append(chosenFor, pubkey)
+			}
+		}
+		cover.Relays[best] = chosenFor
+		cover.Order = append(cover.Order, best)
+		if maxRelays > 0 && len(cover.Order) == maxRelays {
+			break
+		}
+	}
+
+	reached := map[nostr.PubKey]struct{}{}
+	for _, pubkeys := range cover.Relays {
+		for _, pubkey := range pubkeys {
+			reached[pubkey] = struct{}{}
+		}
+	}
+	cover.Covered = len(reached)
+	for _, pubkey := range pubkeys {
+		if _, ok := reached[pubkey]; !ok {
+			cover.Uncovered = append(cover.Uncovered, pubkey)
+			reached[pubkey] = struct{}{}
+		}
+	}
+	return cover
+}
+
+// ParseRelayList reads the read and write relays from the `r` tags of a
+// kind 10002 event, an `r` tag without marker being both.
+func ParseRelayList(event nostr.Event) (list RelayList, err error) {
+	if event.Kind != nostr.KindRelayListMetadata {
+		return RelayList{}, fmt.Errorf("event %s is kind %d, not %d", event.ID, event.Kind, nostr.KindRelayListMetadata)
+	}
+	list.PubKey = event.PubKey
+	list.CreatedAt = event.CreatedAt
+	list.Event = event
+	for tag := range event.Tags.FindAll("r") {
+		url := nostr.NormalizeURL(tag[1])
+		if url == "" {
+			continue
+		}
+		marker := ""
+		if len(tag) > 2 {
+			marker = tag[2]
+		}
+		if marker != "write" && !slices.Contains(list.Read, url) {
+			list.Read = append(list.Read, url)
+		}
+		if marker != "read" && !slices.Contains(list.Write, url) {
+			list.Write = append(list.Write, url)
+		}
+	}
+	return list, nil
+}
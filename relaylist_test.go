@@ -0,0 +1,62 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"slices"
+	"testing"
+
+	"fiatjaf.com/nostr"
+)
+
+func TestRelayList(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob, carol := nostr.Generate(), nostr.Generate(), nostr.Generate()
+	if _, _, err := db.saveRelayList(alice,
+		nostr.Tag{"r", "wss://relay.damus.io/"},
+		nostr.Tag{"r", "wss://nos.lol", "write"},
+		nostr.Tag{"r", "wss://inbox.example.com", "read"},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.saveRelayList(bob,
+		nostr.Tag{"r", "wss://nos.lol"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := db.GetRelayList(alice.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(list.Read, []string{"wss://relay.damus.io", "wss://inbox.example.com"}) {
+		t.Fatalf("unexpected read relays: %v", list.Read)
+	}
+	if !slices.Equal(list.Write, []string{"wss://relay.damus.io", "wss://nos.lol"}) {
+		t.Fatalf("unexpected write relays: %v", list.Write)
+	}
+
+	pubkeys := []nostr.PubKey{alice.Public(), bob.Public(), carol.Public()}
+	cover, err := db.OutboxRelays(pubkeys, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(cover.Order, []string{"wss://nos.lol"}) {
+		t.Fatalf("expected: [wss://nos.lol], actual: %v", cover.Order)
+	}
+	if cover.Total != 3 || cover.WithRelayList != 2 || cover.Covered != 2 ||
+		!slices.Equal(cover.Uncovered, []nostr.PubKey{carol.Public()}) {
+		t.Fatalf("unexpected stats: %+v", cover)
+	}
+
+	cover, err = db.OutboxRelays(pubkeys, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(cover.Order, []string{"wss://nos.lol", "wss://relay.damus.io"}) {
+		t.Fatalf("unexpected relays: %v", cover.Order)
+	}
+}
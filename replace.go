@@ -3,39 +3,74 @@
 package indexeddb
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"fiatjaf.com/nostr"
-	"fiatjaf.com/nostr/eventstore"
+	"github.com/aperturerobotics/go-indexeddb/idb"
 )
 
+// ReplaceEvent saves the event in place of the older versions of it, in a
+// single transaction. nothing is saved when a newer version is stored.
 func (b *IndexeddbBackend) ReplaceEvent(evt nostr.Event) error {
+	if !isStored(evt.Kind) {
+		return nil
+	}
+
+	return b.update(func(ctx context.Context, store *idb.ObjectStore) ([]Change, error) {
+		replaced, stored, err := replaceEvent(ctx, store, evt, time.Now())
+		if err != nil || !stored {
+			return nil, err
+		}
+		return []Change{replaceChange(evt, replaced)}, nil
+	})
+}
 
-	filter := nostr.Filter{Limit: 1, Kinds: []nostr.Kind{evt.Kind}, Authors: []nostr.PubKey{evt.PubKey}}
+// replaceEvent saves an event in place of its older versions, returning them.
+// stored is false when the event or a newer version of it is already there.
+// a zero fetchedAt leaves the fetched time of a duplicate as it is.
+func replaceEvent(ctx context.Context, store *idb.ObjectStore, evt nostr.Event, fetchedAt time.Time) (replaced []nostr.Event, stored bool, err error) {
+	var previous []nostr.Event
 	if evt.Kind.IsAddressable() {
-		filter.Tags = nostr.TagMap{"d": []string{evt.Tags.GetD()}}
+		previous, err = addressEvents(ctx, store, nostr.EntityPointer{Kind: evt.Kind, PublicKey: evt.PubKey, Identifier: evt.Tags.GetD()})
+	} else {
+		previous, err = authorEvents(ctx, store, []nostr.Kind{evt.Kind}, evt.PubKey)
+	}
+	if err != nil {
+		return nil, false, err
 	}
 
-	itr := b.QueryEvents(filter, 1000)
-
-	shouldStore := true
-	for previous := range itr {
-		if isOlder(previous, evt) {
-			if err := b.DeleteEvent(previous.ID); err != nil {
-				return fmt.Errorf("failed to delete event for replacing: %w", err)
+	for _, prev := range previous {
+		if prev.ID == evt.ID {
+			// a duplicate still tells that the stored version is current
+			if fetchedAt.IsZero() {
+				return nil, false, nil
 			}
-		} else {
-			shouldStore = false
+			return nil, false, touchEvent(ctx, store, evt.ID, fetchedAt)
+		}
+		if !isOlder(prev, evt) {
+			return nil, false, nil
 		}
 	}
-
-	if shouldStore {
-		if err := b.SaveEvent(evt); err != nil && err != eventstore.ErrDupEvent {
-			return fmt.Errorf("failed to save: %w", err)
+	for _, prev := range previous {
+		if _, _, err := deleteEvent(ctx, store, prev.ID); err != nil {
+			return nil, false, fmt.Errorf("failed to delete event for replacing: %w", err)
 		}
+		replaced = append(replaced, prev)
+	}
+	if err := putEvent(ctx, store, evt, fetchedAt); err != nil {
+		return nil, false, fmt.Errorf("failed to save: %w", err)
 	}
+	return replaced, true, nil
+}
 
-	return nil
+// replaceChange is the change of a stored event that replaced others, if any.
+func replaceChange(evt nostr.Event, replaced []nostr.Event) Change {
+	if len(replaced) == 0 {
+		return Change{Op: ChangeSave, Event: evt}
+	}
+	return Change{Op: ChangeReplace, Event: evt, Replaced: replaced}
 }
 
 func isOlder(previous, next nostr.Event) bool {
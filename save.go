@@ -6,8 +6,10 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"fiatjaf.com/nostr"
 	"github.com/aperturerobotics/go-indexeddb/idb"
@@ -15,28 +17,38 @@ import (
 )
 
 func (b *IndexeddbBackend) SaveEvent(evt nostr.Event) error {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
 	// validate kinds
-	if !evt.Kind.IsReplaceable() && evt.Kind != nostr.KindRecommendServer && !evt.Kind.IsAddressable() {
+	if !isStored(evt.Kind) {
 		return nil
 	}
+	return b.update(func(ctx context.Context, store *idb.ObjectStore) ([]Change, error) {
+		if err := putEvent(ctx, store, evt, time.Now()); err != nil {
+			return nil, err
+		}
+		return []Change{{Op: ChangeSave, Event: evt}}, nil
+	})
+}
 
-	tx, err := b.db.Transaction(idb.TransactionReadWrite, storeNameEvents)
-	if err != nil {
-		return err
-	}
-	store, err := tx.ObjectStore(storeNameEvents)
-	if err != nil {
-		return err
-	}
+// isStored tells if events of a kind are kept, only the meta ones are.
+func isStored(kind nostr.Kind) bool {
+	return kind.IsReplaceable() || kind == nostr.KindRecommendServer || kind.IsAddressable()
+}
 
+// putEvent writes an event with its index entries, the next write sequence and
+// the time it was fetched, zero when unknown, overwriting any record or
+// tombstone with the same id. the event is no longer known missing.
+func putEvent(ctx context.Context, store *idb.ObjectStore, evt nostr.Event, fetchedAt time.Time) error {
 	meta, err := ParseMeta(evt)
 	if err != nil {
 		return err
 	}
 	var metaValue any = nil
-	if meta.Name != "" {
+	if evt.Kind == nostr.KindRecommendServer {
+		// relays are searched by their terms, the url is kept for lookups
+		if meta.URL != "" {
+			metaValue = meta.URL
+		}
+	} else if meta.Name != "" {
 		metaValue = meta.Name
 	} else if meta.URL != "" {
 		metaValue = meta.URL
@@ -49,26 +61,35 @@ func (b *IndexeddbBackend) SaveEvent(evt nostr.Event) error {
 	addressable := evt.Kind.IsAddressable()
 
 	for _, tag := range evt.Tags {
-		if len(tag) < 2 || len(tag[1]) < 1 {
-			continue
-		}
-
 		tagjs := []any{}
 		for _, t := range tag {
 			tagjs = append(tagjs, t)
 		}
 		tags = append(tags, tagjs)
 
-		if len(tag[0]) != 1 {
+		if len(tag) < 2 || len(tag[1]) < 1 || len(tag[0]) != 1 {
 			continue
 		}
 
-		if addressable && tag[0] == "d" {
+		if (addressable && tag[0] == "d") || slices.Contains(indexedTags[evt.Kind], tag[0]) {
 			kta = append(kta, k+tag[0]+tag[1]+p)
 		}
 	}
 
+	caps := []any{}
+	if evt.Kind == nostr.KindRecommendServer {
+		if info, err := ParseRelayInfo(evt); err == nil {
+			for _, c := range relayCaps(info) {
+				caps = append(caps, c)
+			}
+		}
+	}
+
 	sig := hex.EncodeToString(evt.Sig[:])
+	seq, err := nextSeq(ctx, store)
+	if err != nil {
+		return err
+	}
 
 	obj := map[string]any{
 		keyKind:               evt.Kind.Num(),
@@ -79,6 +100,10 @@ func (b *IndexeddbBackend) SaveEvent(evt nostr.Event) error {
 		keySignature:          sig,
 		keyKindTagAuthorArray: kta,
 		keyMeta:               metaValue,
+		keySearchTerms:        searchTerms(evt, meta),
+		keyRelayCaps:          caps,
+		keySeq:                seq,
+		keyFetchedAt:          fetchedMillis(fetchedAt),
 	}
 
 	rawID, err := safejs.ValueOf(evt.ID.Hex())
@@ -90,15 +115,17 @@ func (b *IndexeddbBackend) SaveEvent(evt nostr.Event) error {
 		return err
 	}
 
-	_, err = store.PutKey(rawID, rawObj)
-
+	if _, err := store.PutKey(rawID, rawObj); err != nil {
+		return err
+	}
+	tombstones, err := siblingStore(store, storeNameTombstones)
 	if err != nil {
 		return err
 	}
-	if err := tx.Await(ctx); err != nil {
+	if _, err := tombstones.Delete(rawID); err != nil {
 		return err
 	}
-	return nil
+	return forgetMissing(store, evt)
 }
 
 type Meta struct {
@@ -106,7 +133,36 @@ type Meta struct {
 	URL  string `json:"url,omitempty"`
 }
 
+// indexedTags are the tags indexed besides `d`, for reverse lookups.
+var indexedTags = map[nostr.Kind][]string{
+	nostr.KindFollowList:         {"p"},
+	nostr.KindSimpleGroupAdmins:  {"p"},
+	nostr.KindSimpleGroupMembers: {"p"},
+}
+
+// nameTags maps the addressable kinds we search by name to the tag holding it.
+var nameTags = map[nostr.Kind]string{
+	nostr.KindSimpleGroupMetadata:   "name",
+	nostr.KindCommunityDefinition:   "name",
+	nostr.KindArticle:               "title",
+	nostr.KindCategorizedPeopleList: "title",
+	nostr.KindRelaySets:             "title",
+	nostr.KindBookmarkSets:          "title",
+	nostr.KindCuratedSets:           "title",
+	nostr.KindCuratedVideoSets:      "title",
+	nostr.KindMuteSets:              "title",
+	nostr.KindInterestSets:          "title",
+	nostr.KindEmojiSets:             "title",
+	nostr.KindReleaseArtifactSets:   "title",
+}
+
 func ParseMeta(event nostr.Event) (meta Meta, err error) {
+	if tagName, ok := nameTags[event.Kind]; ok {
+		if tag := event.Tags.Find(tagName); tag != nil {
+			meta.Name = strings.ToLower(strings.TrimSpace(tag[1]))
+		}
+		return meta, nil
+	}
 	if event.Kind != nostr.KindProfileMetadata &&
 		event.Kind != nostr.KindRecommendServer {
 		return Meta{}, nil
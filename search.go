@@ -0,0 +1,677 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip19"
+	"github.com/aperturerobotics/go-indexeddb/idb"
+	"github.com/hack-pad/safejs"
+)
+
+// search scores. an exact match always ranks above a prefix match, the boosts
+// only reorder results within the same group.
+const (
+	scoreExact     = 1000
+	scoreLabel     = 500
+	scoreKnown     = 300
+	scoreVerified  = 200
+	maxTermPenalty = 100
+
+	// urls are indexed by their suffixes up to this length
+	maxURLTermLength = 100
+	// shorter hex searches are taken as names only
+	minHexPrefix = 8
+)
+
+// relayKinds are searched by relay url instead of name.
+var relayKinds = []nostr.Kind{nostr.KindRecommendServer, nostr.KindRelayListMetadata}
+
+// hit is an event matching a search, ranked by the term of its index key and
+// decoded only when it makes the results.
+type hit struct {
+	id        string
+	rawID     safejs.Value
+	value     safejs.Value
+	kind      nostr.Kind
+	pubkey    nostr.PubKey
+	createdAt nostr.Timestamp
+	score     int
+}
+
+// hits keeps the best match of every event.
+type hits struct {
+	list []hit
+	byID map[string]int
+}
+
+func (hs *hits) add(rawID, value safejs.Value, score int) error {
+	id, err := rawID.String()
+	if err != nil {
+		return err
+	}
+	if i, ok := hs.byID[id]; ok {
+		hs.list[i].score = max(hs.list[i].score, score)
+		return nil
+	}
+	h := hit{id: id, rawID: rawID, value: value, score: score}
+	k, err := value.Get(keyKind)
+	if err != nil {
+		return err
+	}
+	kind, err := k.Int()
+	if err != nil {
+		return err
+	}
+	h.kind = nostr.Kind(kind)
+	a, err := value.Get(keyAuthor)
+	if err != nil {
+		return err
+	}
+	pubkey, err := a.String()
+	if err != nil {
+		return err
+	}
+	if h.pubkey, err = nostr.PubKeyFromHex(pubkey); err != nil {
+		return err
+	}
+	ca, err := value.Get(keyCreatedAt)
+	if err != nil {
+		return err
+	}
+	createdAt, err := ca.Float()
+	if err != nil {
+		return err
+	}
+	h.createdAt = nostr.Timestamp(createdAt)
+	hs.byID[id] = len(hs.list)
+	hs.list = append(hs.list, h)
+	return nil
+}
+
+// search collects every event of the searchable kinds matching the search,
+// to be ranked together by rank. names are matched by prefix, relays from any
+// hostname label or path segment of their urls. a search that is an entity
+// returns its events instead, the best `limit` of them (all of them if limit
+// is 0).
+func (b *IndexeddbBackend) search(ctx context.Context, store *idb.ObjectStore, filter nostr.Filter, limit int) ([]nostr.Event, []hit, error) {
+	kinds := searchKinds(filter.Kinds)
+	if len(kinds) == 0 {
+		return nil, nil, fmt.Errorf("unsupported kinds for search: %v", filter.Kinds)
+	}
+	idxMeta, err := store.Index(idxKindMeta)
+	if err != nil {
+		return nil, nil, err
+	}
+	idxTerms, err := store.Index(idxSearchTerms)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	idxAuthor, err := store.Index(idxKindAuthor)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	search := strings.ToLower(strings.TrimSpace(filter.Search))
+	if events, ok, err := b.lookupEntity(ctx, store, filter.Kinds, search); ok || err != nil {
+		if limit > 0 && len(events) > limit {
+			events = events[:limit]
+		}
+		return events, nil, err
+	}
+
+	hs := &hits{byID: map[string]int{}}
+	if len(search) == 64 && isHexPrefix(search) {
+		// a full hex may as well be an event id
+		if err := searchID(ctx, store, kinds, search, hs); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, kind := range kinds {
+		if slices.Contains(relayKinds, kind) {
+			err = searchRelayKind(ctx, idxTerms, kind, stripScheme(search), hs)
+		} else {
+			err = searchKind(ctx, idxMeta, kind, search, termScore, hs)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if isHexPrefix(search) {
+			if err := searchAuthorPrefix(ctx, idxAuthor, kind, search, hs); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return nil, hs.list, nil
+}
+
+// lookupEntity resolves a search that is a NIP-19 entity against the store:
+// npub and nprofile to the events of the author (profiles unless kinds are
+// given), note and nevent to the event, naddr to the addressable event.
+// ok is false when the search isn't an entity.
+func (b *IndexeddbBackend) lookupEntity(ctx context.Context, store *idb.ObjectStore, kinds []nostr.Kind, search string) (events []nostr.Event, ok bool, err error) {
+	_, value, err := nip19.Decode(strings.TrimPrefix(search, "nostr:"))
+	if err != nil {
+		return nil, false, nil
+	}
+
+	var ids []nostr.ID
+	switch v := value.(type) {
+	case nostr.PubKey:
+		events, err = authorEvents(ctx, store, kinds, v)
+		return events, true, err
+	case nostr.ProfilePointer:
+		events, err = authorEvents(ctx, store, kinds, v.PublicKey)
+		return events, true, err
+	case nostr.EntityPointer:
+		if len(kinds) > 0 && !slices.Contains(kinds, v.Kind) {
+			return nil, true, nil
+		}
+		events, err = addressEvents(ctx, store, v)
+		return events, true, err
+	case [32]byte:
+		ids = []nostr.ID{nostr.ID(v)}
+	case nostr.EventPointer:
+		ids = []nostr.ID{v.ID}
+	default:
+		// a valid entity we don't look up, like nsec
+		return nil, true, nil
+	}
+
+	for _, id := range ids {
+		evt, found, err := getEvent(ctx, store, id)
+		if err != nil {
+			return nil, true, err
+		}
+		if found && (len(kinds) == 0 || slices.Contains(kinds, evt.Kind)) {
+			events = append(events, evt)
+		}
+	}
+	return events, true, nil
+}
+
+// searchID adds the event of a kind whose id is the hex search.
+func searchID(ctx context.Context, store *idb.ObjectStore, kinds []nostr.Kind, search string, hs *hits) error {
+	rawID, err := safejs.ValueOf(search)
+	if err != nil {
+		return err
+	}
+	req, err := store.Get(rawID)
+	if err != nil {
+		return err
+	}
+	value, err := req.Await(ctx)
+	if err != nil || value.IsUndefined() || value.IsNull() {
+		return err
+	}
+	k, err := value.Get(keyKind)
+	if err != nil {
+		return err
+	}
+	kind, err := k.Int()
+	if err != nil || !slices.Contains(kinds, nostr.Kind(kind)) {
+		return err
+	}
+	return hs.add(rawID, value, scoreExact)
+}
+
+// searchAuthorPrefix adds the events of a kind whose author starts with the
+// hex search.
+func searchAuthorPrefix(ctx context.Context, idx *idb.Index, kind nostr.Kind, search string, hs *hits) error {
+	req, err := openPrefix(idx, kind, search)
+	if err != nil {
+		return err
+	}
+	score := scoreLabel
+	if len(search) == 64 {
+		score = scoreExact
+	}
+	return req.Iter(ctx, func(cursor *idb.CursorWithValue) error {
+		id, err := cursor.PrimaryKey()
+		if err != nil {
+			return err
+		}
+		value, err := cursor.Value()
+		if err != nil {
+			return err
+		}
+		return hs.add(id, value, score)
+	})
+}
+
+// searchKind adds the prefix matches of a single kind, rated by the term of
+// their index key.
+func searchKind(ctx context.Context, idx *idb.Index, kind nostr.Kind, search string, score func(term, search string) int, hs *hits) error {
+	req, err := openPrefix(idx, kind, search)
+	if err != nil {
+		return err
+	}
+	return req.Iter(ctx, func(cursor *idb.CursorWithValue) error {
+		key, err := cursor.Key()
+		if err != nil {
+			return err
+		}
+		rawTerm, err := key.Index(1)
+		if err != nil {
+			return err
+		}
+		term, err := rawTerm.String()
+		if err != nil {
+			return err
+		}
+		id, err := cursor.PrimaryKey()
+		if err != nil {
+			return err
+		}
+		value, err := cursor.Value()
+		if err != nil {
+			return err
+		}
+		return hs.add(id, value, score(term, search))
+	})
+}
+
+// searchRelayKind adds the relay events of a kind matching the search, rated
+// by their best url like SearchRelays, as the term of a substring match
+// doesn't tell whether it starts a label.
+func searchRelayKind(ctx context.Context, idx *idb.Index, kind nostr.Kind, search string, hs *hits) error {
+	req, err := openPrefix(idx, kind, search)
+	if err != nil {
+		return err
+	}
+	seen := map[string]struct{}{}
+	return req.Iter(ctx, func(cursor *idb.CursorWithValue) error {
+		evt, ok, err := cursorEventOnce(cursor, seen)
+		if err != nil || !ok {
+			return err
+		}
+		scores := relayScores(evt, search)
+		if len(scores) == 0 {
+			return nil
+		}
+		id, err := cursor.PrimaryKey()
+		if err != nil {
+			return err
+		}
+		value, err := cursor.Value()
+		if err != nil {
+			return err
+		}
+		return hs.add(id, value, slices.Max(slices.Collect(maps.Values(scores))))
+	})
+}
+
+// SearchRelays returns the urls of every relay we know about, from relay infos
+// (kind 2) and relay lists (kind 10002), that contain the search in any part
+// of their url or start a word of their name. the best matches come first, then the most listed ones.
+func (b *IndexeddbBackend) SearchRelays(search string, limit int) ([]string, error) {
+	type relay struct {
+		url   string
+		score int
+		count int
+	}
+	search = stripScheme(strings.ToLower(strings.TrimSpace(search)))
+	relays := map[string]*relay{}
+	if err := b.view(func(ctx context.Context, store *idb.ObjectStore) error {
+		idx, err := store.Index(idxSearchTerms)
+		if err != nil {
+			return err
+		}
+		for _, kind := range relayKinds {
+			req, err := openPrefix(idx, kind, search)
+			if err != nil {
+				return err
+			}
+			seen := map[string]struct{}{}
+			if err := req.Iter(ctx, func(cursor *idb.CursorWithValue) error {
+				evt, ok, err := cursorEventOnce(cursor, seen)
+				if err != nil || !ok {
+					return err
+				}
+				for url, score := range relayScores(evt, search) {
+					r, ok := relays[url]
+					if !ok {
+						r = &relay{url: url, score: score}
+						relays[url] = r
+					}
+					r.score = max(r.score, score)
+					r.count++
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sorted := make([]*relay, 0, len(relays))
+	for _, r := range relays {
+		sorted = append(sorted, r)
+	}
+	slices.SortFunc(sorted, func(a, b *relay) int {
+		if c := cmp.Compare(b.score, a.score); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.count, a.count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.url, b.url)
+	})
+	if limit > 0 && len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	urls := make([]string, len(sorted))
+	for i, r := range sorted {
+		urls[i] = r.url
+	}
+	return urls, nil
+}
+
+// openPrefix opens a cursor over the [kind, term] index entries whose term
+// starts with search.
+func openPrefix(idx *idb.Index, kind nostr.Kind, search string) (*idb.CursorWithValueRequest, error) {
+	lower, err := safejs.ValueOf([]any{kind.Num(), search})
+	if err != nil {
+		return nil, err
+	}
+	upper, err := safejs.ValueOf([]any{kind.Num(), search + "\uffff"})
+	if err != nil {
+		return nil, err
+	}
+	rb, err := idb.NewKeyRangeBound(lower, upper, false, false)
+	if err != nil {
+		return nil, err
+	}
+	return idx.OpenCursorRange(rb, idb.CursorNext)
+}
+
+// cursorEventOnce decodes the event under a multi entry index cursor, unless
+// it was already seen through another entry.
+func cursorEventOnce(cursor *idb.CursorWithValue, seen map[string]struct{}) (nostr.Event, bool, error) {
+	id, err := cursor.PrimaryKey()
+	if err != nil {
+		return nostr.Event{}, false, err
+	}
+	d, err := id.String()
+	if err != nil {
+		return nostr.Event{}, false, err
+	}
+	if _, ok := seen[d]; ok {
+		return nostr.Event{}, false, nil
+	}
+	seen[d] = struct{}{}
+	rawEvt, err := cursor.Value()
+	if err != nil {
+		return nostr.Event{}, false, err
+	}
+	evt, err := valueToEvent(id, rawEvt)
+	if err != nil {
+		return nostr.Event{}, false, err
+	}
+	return evt, true, nil
+}
+
+// searchKinds keeps the searchable kinds of a filter, or returns all of them
+// when the filter has no kinds.
+func searchKinds(kinds []nostr.Kind) []nostr.Kind {
+	if len(kinds) == 0 {
+		named := make([]nostr.Kind, 0, len(nameTags))
+		for kind := range nameTags {
+			named = append(named, kind)
+		}
+		slices.Sort(named)
+		return append([]nostr.Kind{nostr.KindProfileMetadata, nostr.KindRecommendServer, nostr.KindRelayListMetadata}, named...)
+	}
+	res := make([]nostr.Kind, 0, len(kinds))
+	for _, kind := range kinds {
+		if slices.Contains(res, kind) {
+			continue
+		}
+		if _, ok := nameTags[kind]; !ok &&
+			kind != nostr.KindProfileMetadata && !slices.Contains(relayKinds, kind) {
+			logWarn(fmt.Sprintf("unsupported kind for search: %d", kind))
+			continue
+		}
+		res = append(res, kind)
+	}
+	return res
+}
+
+// termScore rates how well an indexed name matches the search.
+func termScore(term, search string) int {
+	score := -min(utf8.RuneCountInString(term), maxTermPenalty)
+	if term == search {
+		score += scoreExact
+	}
+	return score
+}
+
+// relayScores rates every relay of an event matching the search: the exact
+// host or name first, then a match at the start of a hostname label, a path
+// segment or a word of the name, then any substring of the url, shorter ones
+// first.
+func relayScores(evt nostr.Event, search string) map[string]int {
+	scores := map[string]int{}
+	var name string
+	if evt.Kind == nostr.KindRecommendServer {
+		meta, _ := ParseMeta(evt)
+		name = meta.Name
+	}
+	for _, url := range relayURLs(evt) {
+		s := stripScheme(url)
+		host, _, _ := strings.Cut(s, "/")
+		score := -min(utf8.RuneCountInString(s), maxTermPenalty)
+		switch {
+		case s == search || host == search || (name != "" && name == search):
+			score += scoreExact
+		case strings.HasPrefix(s, search) || strings.Contains(s, "."+search) || strings.Contains(s, "/"+search) ||
+			strings.HasPrefix(name, search) || strings.Contains(name, " "+search):
+			score += scoreLabel
+		case strings.Contains(s, search):
+		default:
+			continue
+		}
+		scores[url] = score
+	}
+	return scores
+}
+
+// boost rates the author of a search result.
+func (b *IndexeddbBackend) boost(h hit) int {
+	score := 0
+	if b.KnownPubKey != nil && b.KnownPubKey(h.pubkey) {
+		score += scoreKnown
+	}
+	if b.VerifiedNIP05 != nil && h.kind == nostr.KindProfileMetadata {
+		var profile struct {
+			NIP05 string `json:"nip05"`
+		}
+		if content, err := h.value.Get(keyContent); err == nil {
+			if c, err := content.String(); err == nil && json.Unmarshal([]byte(c), &profile) == nil &&
+				profile.NIP05 != "" && b.VerifiedNIP05(h.pubkey, profile.NIP05) {
+				score += scoreVerified
+			}
+		}
+	}
+	return score
+}
+
+// rank boosts the authors of the hits, sorts them by score, newest first on
+// ties, and decodes the top `limit`. it is called once the transaction of the
+// search is over, KnownPubKey and VerifiedNIP05 may use the store.
+func (b *IndexeddbBackend) rank(hits []hit, limit int) ([]nostr.Event, error) {
+	for i := range hits {
+		hits[i].score += b.boost(hits[i])
+	}
+	slices.SortFunc(hits, func(a, b hit) int {
+		if c := cmp.Compare(b.score, a.score); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.createdAt, a.createdAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.id, b.id)
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	events := make([]nostr.Event, 0, len(hits))
+	for _, h := range hits {
+		evt, err := valueToEvent(h.rawID, h.value)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, evt)
+	}
+	return events, nil
+}
+
+// searchTerms lists the [kind, term] index keys relays are found by: their
+// urls from every hostname label and path segment, and the words of their
+// names.
+func searchTerms(evt nostr.Event, meta Meta) []any {
+	terms := []string{}
+	for _, url := range relayURLs(evt) {
+		terms = append(terms, relayTerms(url)...)
+	}
+	if evt.Kind == nostr.KindRecommendServer && meta.Name != "" {
+		terms = append(terms, meta.Name)
+		for i, r := range meta.Name {
+			if r == ' ' {
+				terms = append(terms, meta.Name[i+1:])
+			}
+		}
+	}
+
+	keys := make([]any, 0, len(terms))
+	seen := map[string]struct{}{}
+	for _, term := range terms {
+		if _, ok := seen[term]; ok || term == "" {
+			continue
+		}
+		seen[term] = struct{}{}
+		keys = append(keys, []any{evt.Kind.Num(), term})
+	}
+	return keys
+}
+
+// relayTerms lists the parts of a url a prefix scan finds it by: every suffix
+// of the url without its scheme, so that any substring matches, cut to
+// maxURLTermLength, and the host alone.
+func relayTerms(url string) []string {
+	s := stripScheme(url)
+	terms := []string{}
+	for i := range s {
+		terms = append(terms, truncate(s[i:], maxURLTermLength))
+	}
+	if host, _, ok := strings.Cut(s, "/"); ok {
+		terms = append(terms, truncate(host, maxURLTermLength))
+	}
+	return terms
+}
+
+// truncate cuts s to at most n bytes, on a rune boundary.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// relayURLs returns the normalized relay urls an event is about.
+func relayURLs(evt nostr.Event) []string {
+	switch evt.Kind {
+	case nostr.KindRecommendServer:
+		if meta, _ := ParseMeta(evt); meta.URL != "" {
+			return []string{meta.URL}
+		}
+	case nostr.KindRelayListMetadata:
+		urls := []string{}
+		for tag := range evt.Tags.FindAll("r") {
+			if url := nostr.NormalizeURL(tag[1]); url != "" && !slices.Contains(urls, url) {
+				urls = append(urls, url)
+			}
+		}
+		return urls
+	}
+	return nil
+}
+
+// stripScheme lowercases a url and drops its scheme and trailing slash.
+func stripScheme(url string) string {
+	url = strings.ToLower(url)
+	if _, rest, ok := strings.Cut(url, "://"); ok {
+		url = rest
+	}
+	return strings.TrimSuffix(url, "/")
+}
+
+// isHexPrefix tells if a search may be the start of a hex pubkey.
+func isHexPrefix(search string) bool {
+	if len(search) < minHexPrefix || len(search) > 64 {
+		return false
+	}
+	for _, c := range search {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// searchLimit is filter.Limit capped by maxLimit, 0 meaning no limit.
+func searchLimit(filter nostr.Filter, maxLimit int) int {
+	limit := filter.Limit
+	if maxLimit > 0 && (limit <= 0 || limit > maxLimit) {
+		limit = maxLimit
+	}
+	return limit
+}
+
+// matchesSearch tells if an event would be found by the search, for the
+// events that didn't go through the index.
+func matchesSearch(evt nostr.Event, search string) bool {
+	search = strings.ToLower(strings.TrimSpace(search))
+	if _, value, err := nip19.Decode(strings.TrimPrefix(search, "nostr:")); err == nil {
+		switch v := value.(type) {
+		case nostr.PubKey:
+			return evt.PubKey == v
+		case nostr.ProfilePointer:
+			return evt.PubKey == v.PublicKey
+		case nostr.EntityPointer:
+			return evt.Kind == v.Kind && evt.PubKey == v.PublicKey && evt.Tags.GetD() == v.Identifier
+		case [32]byte:
+			return evt.ID == nostr.ID(v)
+		case nostr.EventPointer:
+			return evt.ID == v.ID
+		}
+		return false
+	}
+	if !slices.Contains(searchKinds(nil), evt.Kind) {
+		return false
+	}
+	if isHexPrefix(search) && (strings.HasPrefix(evt.PubKey.Hex(), search) || evt.ID.Hex() == search) {
+		return true
+	}
+	if slices.Contains(relayKinds, evt.Kind) {
+		return len(relayScores(evt, stripScheme(search))) > 0
+	}
+	meta, _ := ParseMeta(evt)
+	return strings.HasPrefix(meta.Name, search) || (meta.Name == "" && strings.HasPrefix(meta.URL, search))
+}
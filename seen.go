@@ -0,0 +1,286 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/aperturerobotics/go-indexeddb/idb"
+	"github.com/hack-pad/safejs"
+)
+
+// Seen is a relay an event, or the events of an author, were seen on.
+type Seen struct {
+	Relay string
+	// Events is how many distinct events were seen there, 1 for a single one.
+	Events    int
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// SaveEventFrom is SaveEvent recording the relay that delivered the event.
+// the relay is recorded even for the kinds that aren't stored, for
+// SeenRetention like every relay an event was seen on.
+func (b *IndexeddbBackend) SaveEventFrom(evt nostr.Event, relay string) error {
+	return b.update(func(ctx context.Context, store *idb.ObjectStore) ([]Change, error) {
+		if err := b.markSeen(ctx, store, evt, []string{relay}); err != nil {
+			return nil, err
+		}
+		if !isStored(evt.Kind) {
+			return nil, nil
+		}
+		if err := putEvent(ctx, store, evt, time.Now()); err != nil {
+			return nil, err
+		}
+		return []Change{{Op: ChangeSave, Event: evt}}, nil
+	})
+}
+
+// ReplaceEventFrom is ReplaceEvent recording the relay that delivered the
+// event, also when an older version was delivered.
+func (b *IndexeddbBackend) ReplaceEventFrom(evt nostr.Event, relay string) error {
+	return b.update(func(ctx context.Context, store *idb.ObjectStore) ([]Change, error) {
+		if err := b.markSeen(ctx, store, evt, []string{relay}); err != nil {
+			return nil, err
+		}
+		if !isStored(evt.Kind) {
+			return nil, nil
+		}
+		replaced, stored, err := replaceEvent(ctx, store, evt, time.Now())
+		if err != nil || !stored {
+			return nil, err
+		}
+		return []Change{replaceChange(evt, replaced)}, nil
+	})
+}
+
+// MarkSeen records that an event was seen on relays, without saving it.
+func (b *IndexeddbBackend) MarkSeen(evt nostr.Event, relays ...string) error {
+	return b.update(func(ctx context.Context, store *idb.ObjectStore) ([]Change, error) {
+		return nil, b.markSeen(ctx, store, evt, relays)
+	})
+}
+
+// SeenOn returns the relays an event was seen on, the most recent first. they
+// are kept after the event is deleted or replaced.
+func (b *IndexeddbBackend) SeenOn(id nostr.ID) ([]Seen, error) {
+	seen := []Seen{}
+	err := b.view(func(ctx context.Context, store *idb.ObjectStore) error {
+		seenStore, err := siblingStore(store, storeNameSeen)
+		if err != nil {
+			return err
+		}
+		rb, err := prefixRange(id.Hex())
+		if err != nil {
+			return err
+		}
+		req, err := seenStore.OpenCursorRange(rb, idb.CursorNext)
+		if err != nil {
+			return err
+		}
+		return req.Iter(ctx, func(cursor *idb.CursorWithValue) error {
+			value, err := cursor.Value()
+			if err != nil {
+				return err
+			}
+			s, err := valueToSeen(value)
+			if err != nil {
+				return err
+			}
+			seen = append(seen, s)
+			return nil
+		})
+	})
+	sortSeen(seen)
+	return seen, err
+}
+
+// AuthorRelays returns the relays events of an author were seen on, the most
+// recent first, e.g. for relay hints or to pick outbox relays.
+func (b *IndexeddbBackend) AuthorRelays(pubkey nostr.PubKey) ([]Seen, error) {
+	seen := []Seen{}
+	err := b.view(func(ctx context.Context, store *idb.ObjectStore) error {
+		seenStore, err := siblingStore(store, storeNameSeen)
+		if err != nil {
+			return err
+		}
+		idx, err := seenStore.Index(idxAuthorRelay)
+		if err != nil {
+			return err
+		}
+		rb, err := prefixRange(pubkey.Hex())
+		if err != nil {
+			return err
+		}
+		req, err := idx.OpenCursorRange(rb, idb.CursorNext)
+		if err != nil {
+			return err
+		}
+		// the index is ordered by relay, so the events of a relay are together
+		return req.Iter(ctx, func(cursor *idb.CursorWithValue) error {
+			value, err := cursor.Value()
+			if err != nil {
+				return err
+			}
+			s, err := valueToSeen(value)
+			if err != nil {
+				return err
+			}
+			if last := len(seen) - 1; last >= 0 && seen[last].Relay == s.Relay {
+				seen[last].Events++
+				if s.FirstSeen.Before(seen[last].FirstSeen) {
+					seen[last].FirstSeen = s.FirstSeen
+				}
+				if s.LastSeen.After(seen[last].LastSeen) {
+					seen[last].LastSeen = s.LastSeen
+				}
+				return nil
+			}
+			seen = append(seen, s)
+			return nil
+		})
+	})
+	sortSeen(seen)
+	return seen, err
+}
+
+// markSeen records an event on relays, after purging the records last seen
+// before the retention.
+func (b *IndexeddbBackend) markSeen(ctx context.Context, store *idb.ObjectStore, evt nostr.Event, relays []string) error {
+	seenStore, err := siblingStore(store, storeNameSeen)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := purgeSeen(ctx, seenStore, now.Add(-b.seenRetention())); err != nil {
+		return err
+	}
+	for _, relay := range relays {
+		if err := markSeenOn(ctx, seenStore, evt, relay, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markSeenOn records an event on a relay, keeping the first time it was seen.
+func markSeenOn(ctx context.Context, seenStore *idb.ObjectStore, evt nostr.Event, relay string, now time.Time) error {
+	relay = nostr.NormalizeURL(relay)
+	if relay == "" {
+		return nil
+	}
+	key, err := safejs.ValueOf([]any{evt.ID.Hex(), relay})
+	if err != nil {
+		return err
+	}
+	req, err := seenStore.Get(key)
+	if err != nil {
+		return err
+	}
+	prev, err := req.Await(ctx)
+	if err != nil {
+		return err
+	}
+	first := float64(now.UnixMilli())
+	if !prev.IsUndefined() && !prev.IsNull() {
+		fs, err := prev.Get(keyFirstSeen)
+		if err != nil {
+			return err
+		}
+		if first, err = fs.Float(); err != nil {
+			return err
+		}
+	}
+	value, err := safejs.ValueOf(map[string]any{
+		keyAuthor:    evt.PubKey.Hex(),
+		keyRelay:     relay,
+		keyFirstSeen: first,
+		keyLastSeen:  float64(now.UnixMilli()),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = seenStore.PutKey(key, value)
+	return err
+}
+
+// purgeSeen deletes the records last seen before the cutoff.
+func purgeSeen(ctx context.Context, seenStore *idb.ObjectStore, cutoff time.Time) error {
+	idx, err := seenStore.Index(idxLastSeen)
+	if err != nil {
+		return err
+	}
+	upper, err := safejs.ValueOf(float64(cutoff.UnixMilli()))
+	if err != nil {
+		return err
+	}
+	rb, err := idb.NewKeyRangeUpperBound(upper, true)
+	if err != nil {
+		return err
+	}
+	req, err := idx.OpenCursorRange(rb, idb.CursorNext)
+	if err != nil {
+		return err
+	}
+	return req.Iter(ctx, func(cursor *idb.CursorWithValue) error {
+		_, err := cursor.Delete()
+		return err
+	})
+}
+
+// prefixRange is the range of the array keys starting with a value.
+func prefixRange(prefix string) (*idb.KeyRange, error) {
+	lower, err := safejs.ValueOf([]any{prefix})
+	if err != nil {
+		return nil, err
+	}
+	upper, err := safejs.ValueOf([]any{prefix, "\uffff"})
+	if err != nil {
+		return nil, err
+	}
+	return idb.NewKeyRangeBound(lower, upper, false, false)
+}
+
+func sortSeen(seen []Seen) {
+	slices.SortFunc(seen, func(a, b Seen) int {
+		return cmp.Or(b.LastSeen.Compare(a.LastSeen), strings.Compare(a.Relay, b.Relay))
+	})
+}
+
+func valueToSeen(value safejs.Value) (Seen, error) {
+	r_, err := value.Get(keyRelay)
+	if err != nil {
+		return Seen{}, err
+	}
+	relay, err := r_.String()
+	if err != nil {
+		return Seen{}, err
+	}
+	fs_, err := value.Get(keyFirstSeen)
+	if err != nil {
+		return Seen{}, err
+	}
+	fs, err := fs_.Float()
+	if err != nil {
+		return Seen{}, err
+	}
+	ls_, err := value.Get(keyLastSeen)
+	if err != nil {
+		return Seen{}, err
+	}
+	ls, err := ls_.Float()
+	if err != nil {
+		return Seen{}, err
+	}
+	return Seen{
+		Relay:     relay,
+		Events:    1,
+		FirstSeen: time.UnixMilli(int64(fs)),
+		LastSeen:  time.UnixMilli(int64(ls)),
+	}, nil
+}
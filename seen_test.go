@@ -0,0 +1,117 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"testing"
+	"time"
+
+	"fiatjaf.com/nostr"
+)
+
+func TestSeen(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk := nostr.Generate()
+
+	profile := nostr.Event{Kind: nostr.KindProfileMetadata, CreatedAt: nostr.Now(), Tags: nostr.Tags{}, Content: "{}"}
+	if err := profile.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ReplaceEventFrom(profile, "wss://a.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveEventFrom(profile, "b.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	// not stored, but still a hint for the author
+	note := nostr.Event{Kind: nostr.KindTextNote, CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	if err := note.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveEventFrom(note, "wss://a.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !db.IsExisted(db.ctx, profile.ID.Hex()) {
+		t.Fatal("expected the profile to be stored")
+	}
+
+	seen, err := db.SeenOn(profile.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 2 {
+		t.Fatalf("expected: 2 relays, actual: %+v", seen)
+	}
+	first := map[string]Seen{}
+	for _, s := range seen {
+		if s.Events != 1 || s.FirstSeen.IsZero() || s.LastSeen.Before(s.FirstSeen) {
+			t.Fatalf("unexpected seen: %+v", s)
+		}
+		first[s.Relay] = s
+	}
+	if _, ok := first["wss://b.example.com"]; !ok {
+		t.Fatalf("expected the relay to be normalized: %+v", seen)
+	}
+
+	if err := db.MarkSeen(profile, "wss://a.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	seen, err = db.SeenOn(profile.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen[0].Relay != "wss://a.example.com" {
+		t.Fatalf("expected the last seen relay first: %+v", seen)
+	}
+	if !seen[0].FirstSeen.Equal(first["wss://a.example.com"].FirstSeen) {
+		t.Fatalf("expected the first seen time to be kept: %+v", seen[0])
+	}
+
+	relays, err := db.AuthorRelays(sk.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(relays) != 2 || relays[0].Relay != "wss://a.example.com" || relays[0].Events != 2 || relays[1].Events != 1 {
+		t.Fatalf("unexpected author relays: %+v", relays)
+	}
+}
+
+func TestSeenRetention(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SeenRetention = 50 * time.Millisecond
+	sk := nostr.Generate()
+	old := nostr.Event{Kind: nostr.KindTextNote, CreatedAt: nostr.Now(), Tags: nostr.Tags{}, Content: "old"}
+	if err := old.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.MarkSeen(old, "wss://a.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	recent := nostr.Event{Kind: nostr.KindTextNote, CreatedAt: nostr.Now(), Tags: nostr.Tags{}, Content: "recent"}
+	if err := recent.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.MarkSeen(recent, "wss://b.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the record past the retention was purged by the later write
+	if seen, err := db.SeenOn(old.ID); err != nil || len(seen) != 0 {
+		t.Fatalf("expected: no relay, actual: %+v, %v", seen, err)
+	}
+	seen, err := db.AuthorRelays(sk.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 1 || seen[0].Relay != "wss://b.example.com" {
+		t.Fatalf("expected: [wss://b.example.com], actual: %+v", seen)
+	}
+}
@@ -0,0 +1,284 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+
+	"fiatjaf.com/nostr"
+)
+
+// ChangeOp is what a Change is about.
+type ChangeOp int
+
+const (
+	// ChangeStored is an event that was already stored when subscribing.
+	ChangeStored ChangeOp = iota
+	// ChangeEOSE comes once after the stored events, the live changes follow.
+	ChangeEOSE
+	// ChangeSave is a newly saved event.
+	ChangeSave
+	// ChangeReplace is a saved event that replaced older versions of it.
+	ChangeReplace
+	// ChangeDelete is a deleted event.
+	ChangeDelete
+)
+
+func (op ChangeOp) String() string {
+	switch op {
+	case ChangeStored:
+		return "stored"
+	case ChangeEOSE:
+		return "eose"
+	case ChangeSave:
+		return "save"
+	case ChangeReplace:
+		return "replace"
+	case ChangeDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// Change is a stored event or a change of the store matching a subscription.
+type Change struct {
+	Op    ChangeOp
+	Event nostr.Event
+	// Replaced are the older versions a ChangeReplace removed.
+	Replaced []nostr.Event
+	// Remote tells that the change was made by another tab. the deleted and
+	// replaced events of a remote change only have their id, kind and pubkey.
+	Remote bool
+}
+
+// Subscribe sends the stored events matching the filter, up to its limit and
+// newest first or by rank for a search, then ChangeEOSE, then every later
+// save, replace and delete of a matching event, like a local relay would. a replace whose new version doesn't match anymore is sent as the
+// deletion of the versions that did. an event saved while the stored ones are
+// read may be sent twice. the channel is closed after cancel is called or the
+// backend is closed, and right away when it isn't open.
+func (b *IndexeddbBackend) Subscribe(filter nostr.Filter) (<-chan Change, func()) {
+	changes, cancel, err := b.subscribe(filter)
+	if err != nil {
+		logErr(err)
+	}
+	return changes, cancel
+}
+
+// subscribe is Subscribe failing with ErrClosed when the backend isn't open.
+func (b *IndexeddbBackend) subscribe(filter nostr.Filter) (<-chan Change, func(), error) {
+	sub := &subscription{
+		filter: filter,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		out:    make(chan Change),
+	}
+	// the slot is held until the subscription is added, so that Close, which
+	// waits for it, then closes the subscription too
+	if err := b.acquire(); err != nil {
+		close(sub.out)
+		return sub.out, func() {}, err
+	}
+	id := b.subs.add(sub)
+	b.release()
+	go sub.run()
+	go func() {
+		// the query only reads by ids, kinds, authors and tags, the rest of the
+		// filter is applied here, a search being already ranked
+		rest := filter
+		rest.Search = ""
+		stored := []Change{}
+		for evt := range b.QueryEvents(filter, 0) {
+			if rest.Matches(evt) {
+				stored = append(stored, Change{Op: ChangeStored, Event: evt})
+			}
+		}
+		if filter.Search == "" {
+			slices.SortStableFunc(stored, func(a, b Change) int { return cmp.Compare(b.Event.CreatedAt, a.Event.CreatedAt) })
+		}
+		if filter.LimitZero {
+			stored = nil
+		} else if filter.Limit > 0 && len(stored) > filter.Limit {
+			stored = stored[:filter.Limit]
+		}
+		sub.start(append(stored, Change{Op: ChangeEOSE}))
+	}()
+	return sub.out, func() {
+		b.subs.remove(id)
+		sub.stop()
+	}, nil
+}
+
+// changed is called after every committed change of the store.
+func (b *IndexeddbBackend) changed(change Change) {
+	b.apply(change)
+	b.broadcast(change)
+}
+
+// apply updates the caches and subscriptions after a change, made here or in
+// another tab.
+func (b *IndexeddbBackend) apply(change Change) {
+	if isTrustKind(change.Event.Kind) {
+		b.forgetTrust()
+	}
+	b.subs.notify(change)
+}
+
+type subscriptions struct {
+	mu   sync.Mutex
+	next int
+	subs map[int]*subscription
+}
+
+func (s *subscriptions) add(sub *subscription) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs == nil {
+		s.subs = map[int]*subscription{}
+	}
+	s.next++
+	s.subs[s.next] = sub
+	return s.next
+}
+
+func (s *subscriptions) remove(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs, id)
+}
+
+// closeAll stops every subscription, closing their channels.
+func (s *subscriptions) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sub := range s.subs {
+		sub.stop()
+	}
+	s.subs = nil
+}
+
+func (s *subscriptions) notify(change Change) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sub := range s.subs {
+		for _, c := range sub.match(change) {
+			sub.push(c)
+		}
+	}
+}
+
+// subscription queues its changes without bound so that writers never wait
+// for a slow reader.
+type subscription struct {
+	filter nostr.Filter
+
+	mu    sync.Mutex
+	queue []Change
+	// live holds the changes happening before the stored events are queued
+	live  []Change
+	ready bool
+
+	wake    chan struct{}
+	done    chan struct{}
+	stopped sync.Once
+	out     chan Change
+}
+
+// match returns what a change of the store means to the subscription.
+func (sub *subscription) match(change Change) []Change {
+	matchesOld := matches
+	if change.Remote {
+		matchesOld = matchesPartial
+	}
+	if change.Op == ChangeDelete {
+		if matchesOld(sub.filter, change.Event) {
+			return []Change{change}
+		}
+		return nil
+	}
+	if matches(sub.filter, change.Event) {
+		return []Change{change}
+	}
+	deletes := []Change{}
+	for _, evt := range change.Replaced {
+		if matchesOld(sub.filter, evt) {
+			deletes = append(deletes, Change{Op: ChangeDelete, Event: evt, Remote: change.Remote})
+		}
+	}
+	return deletes
+}
+
+func (sub *subscription) push(change Change) {
+	sub.mu.Lock()
+	if sub.ready {
+		sub.queue = append(sub.queue, change)
+	} else {
+		sub.live = append(sub.live, change)
+	}
+	sub.mu.Unlock()
+	sub.signal()
+}
+
+// start queues the stored events ahead of the live changes.
+func (sub *subscription) start(stored []Change) {
+	sub.mu.Lock()
+	sub.queue = append(append(sub.queue, stored...), sub.live...)
+	sub.live = nil
+	sub.ready = true
+	sub.mu.Unlock()
+	sub.signal()
+}
+
+func (sub *subscription) stop() {
+	sub.stopped.Do(func() { close(sub.done) })
+}
+
+func (sub *subscription) signal() {
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (sub *subscription) run() {
+	defer close(sub.out)
+	for {
+		sub.mu.Lock()
+		if len(sub.queue) == 0 {
+			sub.mu.Unlock()
+			select {
+			case <-sub.wake:
+				continue
+			case <-sub.done:
+				return
+			}
+		}
+		change := sub.queue[0]
+		sub.queue = sub.queue[1:]
+		sub.mu.Unlock()
+
+		select {
+		case sub.out <- change:
+		case <-sub.done:
+			return
+		}
+	}
+}
+
+// matches tells if a live event matches a filter, searches included.
+func matches(filter nostr.Filter, evt nostr.Event) bool {
+	if !filter.Matches(evt) {
+		return false
+	}
+	return filter.Search == "" || matchesSearch(evt, filter.Search)
+}
+
+// matchesPartial tells if the partial event of a remote change may match a
+// filter, by the only fields it has.
+func matchesPartial(filter nostr.Filter, evt nostr.Event) bool {
+	return (filter.IDs == nil || slices.Contains(filter.IDs, evt.ID)) &&
+		(filter.Kinds == nil || slices.Contains(filter.Kinds, evt.Kind)) &&
+		(filter.Authors == nil || slices.Contains(filter.Authors, evt.PubKey))
+}
@@ -0,0 +1,119 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"testing"
+	"time"
+
+	"fiatjaf.com/nostr"
+)
+
+func receive(t *testing.T, changes <-chan Change, op ChangeOp) Change {
+	t.Helper()
+	select {
+	case change := <-changes:
+		if change.Op != op {
+			t.Fatalf("expected: %s, actual: %s of %s", op, change.Op, change.Event.ID)
+		}
+		return change
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", op)
+	}
+	return Change{}
+}
+
+func TestSubscribe(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk := nostr.Generate()
+	follows, err := db.saveSigned(sk, nostr.KindFollowList)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, cancel := db.Subscribe(nostr.Filter{Kinds: []nostr.Kind{nostr.KindFollowList, nostr.KindMuteList}})
+	if change := receive(t, changes, ChangeStored); change.Event.ID != follows.ID {
+		t.Fatalf("unexpected stored event: %v", change.Event)
+	}
+	receive(t, changes, ChangeEOSE)
+
+	// other kinds are not sent
+	if _, err := db.saveSigned(sk, nostr.KindRelayListMetadata); err != nil {
+		t.Fatal(err)
+	}
+	mutes, err := db.saveSigned(sk, nostr.KindMuteList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change := receive(t, changes, ChangeSave); change.Event.ID != mutes.ID {
+		t.Fatalf("unexpected saved event: %v", change.Event)
+	}
+
+	newer := nostr.Event{Kind: nostr.KindFollowList, CreatedAt: follows.CreatedAt + 1, Tags: nostr.Tags{}}
+	if err := newer.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ReplaceEvent(newer); err != nil {
+		t.Fatal(err)
+	}
+	change := receive(t, changes, ChangeReplace)
+	if change.Event.ID != newer.ID || len(change.Replaced) != 1 || change.Replaced[0].ID != follows.ID {
+		t.Fatalf("unexpected replace: %+v", change)
+	}
+
+	if err := db.DeleteEvent(mutes.ID); err != nil {
+		t.Fatal(err)
+	}
+	if change := receive(t, changes, ChangeDelete); change.Event.ID != mutes.ID {
+		t.Fatalf("unexpected deleted event: %v", change.Event)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-changes:
+		if ok {
+			t.Fatal("unexpected change after cancel")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel not closed after cancel")
+	}
+}
+
+func TestSubscribeStored(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := nostr.Now()
+	events := []nostr.Event{}
+	for i := range 4 {
+		evt := nostr.Event{Kind: nostr.KindFollowList, CreatedAt: now - nostr.Timestamp(i*10), Tags: nostr.Tags{}}
+		if err := evt.Sign(nostr.Generate()); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.ReplaceEvent(evt); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, evt)
+	}
+
+	// the newest one is past until, the oldest before since
+	changes, cancel := db.Subscribe(nostr.Filter{
+		Kinds: []nostr.Kind{nostr.KindFollowList},
+		Since: now - 25,
+		Until: now - 5,
+		Limit: 1,
+	})
+	defer cancel()
+	if change := receive(t, changes, ChangeStored); change.Event.ID != events[1].ID {
+		t.Fatalf("expected: %s, actual: %s", events[1].ID, change.Event.ID)
+	}
+	receive(t, changes, ChangeEOSE)
+
+	changes, cancel = db.Subscribe(nostr.Filter{Kinds: []nostr.Kind{nostr.KindFollowList}, LimitZero: true})
+	defer cancel()
+	receive(t, changes, ChangeEOSE)
+}
@@ -0,0 +1,177 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"sync"
+
+	"fiatjaf.com/nostr"
+)
+
+const (
+	trustSelf       = 1000
+	trustFollow     = 100
+	trustFollower   = 10
+	trustMute       = 20
+	trustMuted      = 1000
+	maxTrustFollows = 10
+)
+
+// Trust is how much a root pubkey trusts another one, from the stored contact
+// lists (kind 3) and mute lists (kind 10000) of the root and of its follows.
+type Trust struct {
+	// Distance is 0 for the root, 1 for its follows, 2 for the follows of its
+	// follows and -1 for anyone else.
+	Distance int
+	// Followers is the number of follows of the root following the pubkey.
+	Followers int
+	// Mutes is the number of follows of the root muting the pubkey.
+	Mutes int
+	// Muted tells if the root itself mutes the pubkey.
+	Muted bool
+	// Score adds up the above, a pubkey with a score above 0 can be trusted.
+	Score int
+}
+
+// trustGraph is the part of the social graph of a root needed to score trust.
+type trustGraph struct {
+	follows    map[nostr.PubKey]struct{}
+	mutes      map[nostr.PubKey]struct{}
+	followedBy map[nostr.PubKey]int
+	mutedBy    map[nostr.PubKey]int
+}
+
+type trustCache struct {
+	mu     sync.Mutex
+	graphs map[nostr.PubKey]*trustGraph
+	// generation counts the changes of the lists, a graph built across one
+	// isn't cached
+	generation int
+}
+
+// TrustScore scores a pubkey relative to a root pubkey, usually the user's.
+// the lists it reads are cached per root until a contact or mute list is
+// saved or an event deleted. search calls KnownPubKey once the index was read,
+// so it may use the store. to boost search results by it:
+//
+//	b.KnownPubKey = func(pubkey nostr.PubKey) bool {
+//		trust, err := b.TrustScore(root, pubkey)
+//		return err == nil && trust.Score > 0
+//	}
+func (b *IndexeddbBackend) TrustScore(root, pubkey nostr.PubKey) (Trust, error) {
+	graph, err := b.trustGraph(root)
+	if err != nil {
+		return Trust{}, err
+	}
+	return graph.score(root, pubkey), nil
+}
+
+// TrustScores scores several pubkeys relative to a root pubkey, e.g. to rank
+// search results or filter out spam profiles.
+func (b *IndexeddbBackend) TrustScores(root nostr.PubKey, pubkeys []nostr.PubKey) (map[nostr.PubKey]Trust, error) {
+	graph, err := b.trustGraph(root)
+	if err != nil {
+		return nil, err
+	}
+	scores := make(map[nostr.PubKey]Trust, len(pubkeys))
+	for _, pubkey := range pubkeys {
+		scores[pubkey] = graph.score(root, pubkey)
+	}
+	return scores, nil
+}
+
+func (b *IndexeddbBackend) trustGraph(root nostr.PubKey) (*trustGraph, error) {
+	b.trust.mu.Lock()
+	graph, ok := b.trust.graphs[root]
+	generation := b.trust.generation
+	b.trust.mu.Unlock()
+	if ok {
+		return graph, nil
+	}
+
+	graph = &trustGraph{
+		follows:    map[nostr.PubKey]struct{}{},
+		mutes:      map[nostr.PubKey]struct{}{},
+		followedBy: map[nostr.PubKey]int{},
+		mutedBy:    map[nostr.PubKey]int{},
+	}
+	follows, err := b.Follows(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, follow := range follows {
+		graph.follows[follow] = struct{}{}
+	}
+	mutes, err := b.getReplaceables(nostr.KindMuteList, []nostr.PubKey{root})
+	if err != nil {
+		return nil, err
+	}
+	for _, mute := range taggedPubKeys(mutes[root]) {
+		graph.mutes[mute] = struct{}{}
+	}
+
+	contacts, err := b.getReplaceables(nostr.KindFollowList, follows)
+	if err != nil {
+		return nil, err
+	}
+	for _, list := range contacts {
+		for _, pubkey := range taggedPubKeys(list) {
+			graph.followedBy[pubkey]++
+		}
+	}
+	mutes, err = b.getReplaceables(nostr.KindMuteList, follows)
+	if err != nil {
+		return nil, err
+	}
+	for _, list := range mutes {
+		for _, pubkey := range taggedPubKeys(list) {
+			graph.mutedBy[pubkey]++
+		}
+	}
+
+	b.trust.mu.Lock()
+	if b.trust.generation == generation {
+		if b.trust.graphs == nil {
+			b.trust.graphs = map[nostr.PubKey]*trustGraph{}
+		}
+		b.trust.graphs[root] = graph
+	}
+	b.trust.mu.Unlock()
+	return graph, nil
+}
+
+// forgetTrust drops the cached graphs after a change of the lists they come from.
+func (b *IndexeddbBackend) forgetTrust() {
+	b.trust.mu.Lock()
+	b.trust.graphs = nil
+	b.trust.generation++
+	b.trust.mu.Unlock()
+}
+
+func (graph *trustGraph) score(root, pubkey nostr.PubKey) Trust {
+	trust := Trust{
+		Distance:  -1,
+		Followers: graph.followedBy[pubkey],
+		Mutes:     graph.mutedBy[pubkey],
+	}
+	_, trust.Muted = graph.mutes[pubkey]
+	if _, ok := graph.follows[pubkey]; pubkey == root {
+		trust.Distance = 0
+		trust.Score = trustSelf
+	} else if ok {
+		trust.Distance = 1
+		trust.Score = trustFollow
+	} else if trust.Followers > 0 {
+		trust.Distance = 2
+	}
+	trust.Score += min(trust.Followers, maxTrustFollows)*trustFollower - trust.Mutes*trustMute
+	if trust.Muted {
+		trust.Score -= trustMuted
+	}
+	return trust
+}
+
+// isTrustKind tells if events of a kind feed the trust graphs.
+func isTrustKind(kind nostr.Kind) bool {
+	return kind == nostr.KindFollowList || kind == nostr.KindMuteList
+}
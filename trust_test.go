@@ -0,0 +1,118 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"testing"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/sdk"
+)
+
+func TestTrustScore(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, alice, bob := nostr.Generate(), nostr.Generate(), nostr.Generate()
+	carol, spammer := nostr.Generate().Public(), nostr.Generate().Public()
+	p := func(pubkey nostr.PubKey) nostr.Tag { return nostr.Tag{"p", pubkey.Hex()} }
+
+	if _, err := db.saveSigned(root, nostr.KindFollowList, p(alice.Public()), p(bob.Public())); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.saveSigned(alice, nostr.KindFollowList, p(carol), p(spammer)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.saveSigned(bob, nostr.KindFollowList, p(carol)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.saveSigned(bob, nostr.KindMuteList, p(spammer)); err != nil {
+		t.Fatal(err)
+	}
+
+	scores, err := db.TrustScores(root.Public(), []nostr.PubKey{root.Public(), alice.Public(), carol, spammer, nostr.Generate().Public()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trust := scores[root.Public()]; trust.Distance != 0 || trust.Score != trustSelf {
+		t.Fatalf("unexpected trust of root: %+v", trust)
+	}
+	if trust := scores[alice.Public()]; trust.Distance != 1 || trust.Score != trustFollow {
+		t.Fatalf("unexpected trust of alice: %+v", trust)
+	}
+	if trust := scores[carol]; trust.Distance != 2 || trust.Followers != 2 || trust.Score != 2*trustFollower {
+		t.Fatalf("unexpected trust of carol: %+v", trust)
+	}
+	if trust := scores[spammer]; trust.Mutes != 1 || trust.Score != trustFollower-trustMute {
+		t.Fatalf("unexpected trust of spammer: %+v", trust)
+	}
+	for pubkey, trust := range scores {
+		if trust.Distance == -1 && trust.Score != 0 {
+			t.Fatalf("unexpected trust of %s: %+v", pubkey, trust)
+		}
+	}
+
+	// muting from the root invalidates the cached graph
+	if _, err := db.saveSigned(root, nostr.KindMuteList, p(carol)); err != nil {
+		t.Fatal(err)
+	}
+	trust, err := db.TrustScore(root.Public(), carol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !trust.Muted || trust.Score >= 0 {
+		t.Fatalf("unexpected trust of muted carol: %+v", trust)
+	}
+}
+
+func TestTrustSearch(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.saveProfile(sdk.ProfileMetadata{Name: "jack"}); err != nil {
+		t.Fatal(err)
+	}
+	_, jackson, err := db.saveProfile(sdk.ProfileMetadata{Name: "jackson"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.saveProfile(sdk.ProfileMetadata{Name: "jackie"}); err != nil {
+		t.Fatal(err)
+	}
+	root := nostr.Generate()
+	if _, err := db.saveSigned(root, nostr.KindFollowList, nostr.Tag{"p", jackson}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the boost reads the store, after the search is done with the index
+	db.KnownPubKey = func(pubkey nostr.PubKey) bool {
+		trust, err := db.TrustScore(root.Public(), pubkey)
+		return err == nil && trust.Score > 0
+	}
+	res := []string{}
+	for evt := range db.QueryEvents(nostr.Filter{Kinds: []nostr.Kind{0}, Search: "jack"}, 0) {
+		meta, err := ParseMeta(evt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, meta.Name)
+	}
+	if len(res) != 3 || res[0] != "jack" || res[1] != "jackson" {
+		t.Fatalf("expected: [jack jackson jackie], actual: %v", res)
+	}
+
+	// the query is over once it returned, Close doesn't wait for it
+	closed := make(chan struct{})
+	go func() {
+		db.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked after the search")
+	}
+}
@@ -0,0 +1,529 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"strings"
+	"sync"
+	"syscall/js"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/eventstore"
+)
+
+var _ eventstore.Store = (*WorkerStore)(nil)
+
+// rpcRequest is a call of a WorkerStore to the backend it's served.
+type rpcRequest struct {
+	ID     int           `json:"id"`
+	Method string        `json:"method"`
+	Filter *nostr.Filter `json:"filter,omitempty"`
+	Limit  int           `json:"limit,omitempty"`
+	Event  *nostr.Event  `json:"event,omitempty"`
+	Target *nostr.ID     `json:"target,omitempty"`
+	// Credit is how many more events a query may send before waiting for
+	// a "more" request, 0 not limiting them.
+	Credit int `json:"credit,omitempty"`
+}
+
+// rpcResponse answers a call, queries sending one per event before the one
+// that is done.
+type rpcResponse struct {
+	ID    int          `json:"id"`
+	Event *nostr.Event `json:"event,omitempty"`
+	Count uint32       `json:"count,omitempty"`
+	Done  bool         `json:"done,omitempty"`
+	Error string       `json:"error,omitempty"`
+}
+
+// queryWindow is the credit of a query of a WorkerStore, topped up once half
+// of it was used.
+const queryWindow = 64
+
+const (
+	rpcInit    = "init"
+	rpcQuery   = "query"
+	rpcCancel  = "cancel"
+	rpcMore    = "more"
+	rpcSave    = "save"
+	rpcReplace = "replace"
+	rpcDelete  = "delete"
+	rpcCount   = "count"
+)
+
+// ServeWorker serves the backend to the WorkerStores of the pages, from the
+// global scope of a dedicated worker or of a shared worker, which then is the
+// single writer of every tab. it returns a function that stops serving.
+func ServeWorker(b *IndexeddbBackend) func() {
+	scope := js.Global()
+	shared := scope.Get("SharedWorkerGlobalScope")
+	if shared.IsUndefined() || !scope.InstanceOf(shared) {
+		return ServePort(b, scope)
+	}
+
+	var mu sync.Mutex
+	stops := []func(){}
+	onConnect := js.FuncOf(func(this js.Value, args []js.Value) any {
+		port := args[0].Get("ports").Index(0)
+		mu.Lock()
+		stops = append(stops, ServePort(b, port))
+		mu.Unlock()
+		return nil
+	})
+	scope.Call("addEventListener", "connect", onConnect)
+	return func() {
+		scope.Call("removeEventListener", "connect", onConnect)
+		onConnect.Release()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, stop := range stops {
+			stop()
+		}
+		stops = nil
+	}
+}
+
+// ServePort serves the backend to the WorkerStore on the other end of a
+// MessagePort, or of anything with postMessage and message events. it returns
+// a function that stops serving and cancels the queries in flight.
+func ServePort(b *IndexeddbBackend, port js.Value) func() {
+	s := &server{backend: b, port: port, queries: map[int]*serverQuery{}}
+	s.onMessage = js.FuncOf(func(this js.Value, args []js.Value) any {
+		data := args[0].Get("data")
+		if data.Type() != js.TypeString {
+			return nil
+		}
+		var req rpcRequest
+		if err := json.Unmarshal([]byte(data.String()), &req); err != nil {
+			logErr(err)
+			return nil
+		}
+		switch req.Method {
+		case rpcCancel:
+			s.cancel(req.ID)
+			return nil
+		case rpcMore:
+			s.more(req.ID, req.Credit)
+			return nil
+		case rpcQuery:
+			// registered in the order of the messages, before any cancel of it
+			s.register(req)
+		}
+		// the backend blocks, which a js callback must not
+		go s.handle(req)
+		return nil
+	})
+	port.Call("addEventListener", "message", s.onMessage)
+	if start := port.Get("start"); start.Type() == js.TypeFunction {
+		port.Call("start")
+	}
+	return s.stop
+}
+
+type server struct {
+	backend   *IndexeddbBackend
+	port      js.Value
+	onMessage js.Func
+
+	mu      sync.Mutex
+	queries map[int]*serverQuery
+	stopped bool
+}
+
+// serverQuery is a query in flight, sending events while it has credit.
+type serverQuery struct {
+	canceled chan struct{}
+	wake     chan struct{}
+	// credit is negative when unlimited
+	credit int
+}
+
+func (s *server) handle(req rpcRequest) {
+	res := rpcResponse{ID: req.ID, Done: true}
+	var err error
+	switch req.Method {
+	case rpcInit:
+		err = s.backend.Init()
+	case rpcQuery, rpcCount:
+		if req.Filter == nil {
+			err = fmt.Errorf("%s without a filter", req.Method)
+		} else if req.Method == rpcCount {
+			res.Count, err = s.backend.CountEvents(*req.Filter)
+		} else {
+			s.query(req)
+		}
+	case rpcSave, rpcReplace:
+		if req.Event == nil {
+			err = fmt.Errorf("%s without an event", req.Method)
+		} else if req.Method == rpcSave {
+			err = s.backend.SaveEvent(*req.Event)
+		} else {
+			err = s.backend.ReplaceEvent(*req.Event)
+		}
+	case rpcDelete:
+		if req.Target == nil {
+			err = fmt.Errorf("%s without a target", req.Method)
+		} else {
+			err = s.backend.DeleteEvent(*req.Target)
+		}
+	default:
+		err = fmt.Errorf("unknown method %q", req.Method)
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	s.post(res)
+}
+
+func (s *server) register(req rpcRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	q := &serverQuery{canceled: make(chan struct{}), wake: make(chan struct{}, 1), credit: req.Credit}
+	if q.credit <= 0 {
+		q.credit = -1
+	}
+	s.queries[req.ID] = q
+}
+
+// query sends the events one by one while it has credit, until the client
+// cancels. the ids are read first, then the events in batches of the credit,
+// each in its own transaction, as a transaction can't wait for the client.
+func (s *server) query(req rpcRequest) {
+	s.mu.Lock()
+	q, ok := s.queries[req.ID]
+	s.mu.Unlock()
+	if !ok {
+		// canceled or stopped already
+		return
+	}
+	defer s.cancel(req.ID)
+
+	ids := s.backend.queryIDs(*req.Filter, req.Limit)
+	for len(ids) > 0 {
+		n, ok := s.take(q, len(ids))
+		if !ok {
+			return
+		}
+		events, err := s.backend.getEvents(ids[:n])
+		if err != nil {
+			logErr(err)
+			return
+		}
+		ids = ids[n:]
+		// the credit of the events deleted since is given back
+		s.more(req.ID, n-len(events))
+		for _, evt := range events {
+			s.post(rpcResponse{ID: req.ID, Event: &evt})
+		}
+	}
+}
+
+// take waits for credit and takes up to max of it, false once the query is
+// canceled.
+func (s *server) take(q *serverQuery, max int) (int, bool) {
+	for {
+		select {
+		case <-q.canceled:
+			return 0, false
+		default:
+		}
+		s.mu.Lock()
+		if q.credit < 0 {
+			s.mu.Unlock()
+			return max, true
+		}
+		if q.credit > 0 {
+			n := min(q.credit, max)
+			q.credit -= n
+			s.mu.Unlock()
+			return n, true
+		}
+		s.mu.Unlock()
+		select {
+		case <-q.wake:
+		case <-q.canceled:
+			return 0, false
+		}
+	}
+}
+
+func (s *server) more(id, credit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q, ok := s.queries[id]; ok && q.credit >= 0 && credit > 0 {
+		q.credit += credit
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *server) cancel(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q, ok := s.queries[id]; ok {
+		close(q.canceled)
+		delete(s.queries, id)
+	}
+}
+
+func (s *server) post(res rpcResponse) {
+	data, err := json.Marshal(res)
+	if err != nil {
+		logErr(err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.stopped {
+		s.port.Call("postMessage", string(data))
+	}
+}
+
+func (s *server) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+	for id, q := range s.queries {
+		close(q.canceled)
+		delete(s.queries, id)
+	}
+	s.port.Call("removeEventListener", "message", s.onMessage)
+	s.onMessage.Release()
+}
+
+// WorkerStore is an eventstore.Store calling the backend served by ServeWorker
+// or ServePort, e.g. to keep decoding events off the main thread.
+type WorkerStore struct {
+	port js.Value
+
+	mu        sync.Mutex
+	next      int
+	calls     map[int]*queue[rpcResponse]
+	onMessage js.Func
+	open      bool
+}
+
+// NewWorkerStore returns a store calling the backend served on a port, a
+// Worker or the port of a SharedWorker.
+func NewWorkerStore(port js.Value) *WorkerStore {
+	return &WorkerStore{port: port}
+}
+
+// Init starts listening to the port and opens the backend, if it isn't
+// already.
+func (w *WorkerStore) Init() error {
+	w.mu.Lock()
+	if !w.open {
+		w.calls = map[int]*queue[rpcResponse]{}
+		w.onMessage = js.FuncOf(func(this js.Value, args []js.Value) any {
+			data := args[0].Get("data")
+			if data.Type() != js.TypeString {
+				return nil
+			}
+			var res rpcResponse
+			if err := json.Unmarshal([]byte(data.String()), &res); err != nil {
+				logErr(err)
+				return nil
+			}
+			w.mu.Lock()
+			c := w.calls[res.ID]
+			w.mu.Unlock()
+			if c != nil {
+				c.push(res)
+			}
+			return nil
+		})
+		w.port.Call("addEventListener", "message", w.onMessage)
+		if start := w.port.Get("start"); start.Type() == js.TypeFunction {
+			w.port.Call("start")
+		}
+		w.open = true
+	}
+	w.mu.Unlock()
+	_, err := w.do(rpcRequest{Method: rpcInit})
+	return err
+}
+
+// Close stops listening to the port, the calls in flight fail with ErrClosed.
+// the backend stays open for the other clients of the worker.
+func (w *WorkerStore) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.open {
+		return
+	}
+	w.port.Call("removeEventListener", "message", w.onMessage)
+	w.onMessage.Release()
+	for _, c := range w.calls {
+		c.push(rpcResponse{Done: true, Error: ErrClosed.Error()})
+	}
+	w.calls = nil
+	w.open = false
+}
+
+func (w *WorkerStore) QueryEvents(filter nostr.Filter, maxLimit int) iter.Seq[nostr.Event] {
+	return func(yield func(nostr.Event) bool) {
+		id, c, err := w.call(rpcRequest{Method: rpcQuery, Filter: &filter, Limit: maxLimit, Credit: queryWindow})
+		if err != nil {
+			logErr(err)
+			return
+		}
+		defer w.forget(id)
+		// the worker sends at most the credit, the rest waits for more
+		credit := queryWindow
+		for {
+			res := c.next()
+			if res.Done {
+				if res.Error != "" {
+					logErr(rpcError(res.Error))
+				}
+				return
+			}
+			if res.Event == nil {
+				continue
+			}
+			if !yield(*res.Event) {
+				w.post(rpcRequest{ID: id, Method: rpcCancel})
+				return
+			}
+			if credit--; credit <= queryWindow/2 {
+				w.post(rpcRequest{ID: id, Method: rpcMore, Credit: queryWindow - credit})
+				credit = queryWindow
+			}
+		}
+	}
+}
+
+func (w *WorkerStore) SaveEvent(evt nostr.Event) error {
+	_, err := w.do(rpcRequest{Method: rpcSave, Event: &evt})
+	return err
+}
+
+func (w *WorkerStore) ReplaceEvent(evt nostr.Event) error {
+	_, err := w.do(rpcRequest{Method: rpcReplace, Event: &evt})
+	return err
+}
+
+func (w *WorkerStore) DeleteEvent(id nostr.ID) error {
+	_, err := w.do(rpcRequest{Method: rpcDelete, Target: &id})
+	return err
+}
+
+func (w *WorkerStore) CountEvents(filter nostr.Filter) (uint32, error) {
+	res, err := w.do(rpcRequest{Method: rpcCount, Filter: &filter})
+	return res.Count, err
+}
+
+// do makes a call answered by a single response.
+func (w *WorkerStore) do(req rpcRequest) (rpcResponse, error) {
+	id, c, err := w.call(req)
+	if err != nil {
+		return rpcResponse{}, err
+	}
+	defer w.forget(id)
+	for {
+		if res := c.next(); res.Done {
+			if res.Error != "" {
+				return res, rpcError(res.Error)
+			}
+			return res, nil
+		}
+	}
+}
+
+// call sends a request, its responses being queued until it's forgotten.
+func (w *WorkerStore) call(req rpcRequest) (int, *queue[rpcResponse], error) {
+	w.mu.Lock()
+	if !w.open {
+		w.mu.Unlock()
+		return 0, nil, ErrClosed
+	}
+	w.next++
+	req.ID = w.next
+	c := newQueue[rpcResponse]()
+	w.calls[req.ID] = c
+	w.mu.Unlock()
+	if err := w.post(req); err != nil {
+		w.forget(req.ID)
+		return 0, nil, err
+	}
+	return req.ID, c, nil
+}
+
+func (w *WorkerStore) forget(id int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.calls, id)
+}
+
+func (w *WorkerStore) post(req rpcRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	w.port.Call("postMessage", string(data))
+	return nil
+}
+
+// queue holds values without bound so that the js callback pushing them
+// never waits.
+type queue[T any] struct {
+	mu     sync.Mutex
+	values []T
+	wake   chan struct{}
+}
+
+func newQueue[T any]() *queue[T] {
+	return &queue[T]{wake: make(chan struct{}, 1)}
+}
+
+func (q *queue[T]) push(value T) {
+	q.mu.Lock()
+	q.values = append(q.values, value)
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next waits for the oldest value and takes it.
+func (q *queue[T]) next() T {
+	for {
+		q.mu.Lock()
+		if len(q.values) > 0 {
+			value := q.values[0]
+			q.values = q.values[1:]
+			q.mu.Unlock()
+			return value
+		}
+		q.mu.Unlock()
+		<-q.wake
+	}
+}
+
+// rpcError turns the message of an error of the backend back into an error,
+// wrapping our own errors so that errors.Is still works across the port.
+func rpcError(message string) error {
+	for _, known := range []error{ErrNotFound, ErrClosed, ErrBlocked} {
+		if message == known.Error() {
+			return known
+		}
+		if prefix, ok := strings.CutSuffix(message, ": "+known.Error()); ok {
+			return fmt.Errorf("%s: %w", prefix, known)
+		}
+	}
+	return errors.New(message)
+}
@@ -0,0 +1,157 @@
+//go:build js
+
+package indexeddb
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"syscall/js"
+	"testing"
+	"time"
+
+	"fiatjaf.com/nostr"
+)
+
+func TestWorker(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipe := js.Global().Get("MessageChannel").New()
+	stop := ServePort(db.IndexeddbBackend, pipe.Get("port1"))
+	defer stop()
+	w := NewWorkerStore(pipe.Get("port2"))
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	sk := nostr.Generate()
+	events := []nostr.Event{}
+	for i := range 3 {
+		evt := nostr.Event{Kind: nostr.KindFollowList, CreatedAt: nostr.Now() + nostr.Timestamp(i), Tags: nostr.Tags{}}
+		if err := evt.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.ReplaceEvent(evt); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, evt)
+	}
+	profile := nostr.Event{Kind: nostr.KindProfileMetadata, CreatedAt: nostr.Now(), Tags: nostr.Tags{}, Content: "{}"}
+	if err := profile.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SaveEvent(profile); err != nil {
+		t.Fatal(err)
+	}
+
+	found := []nostr.Event{}
+	for evt := range w.QueryEvents(nostr.Filter{Kinds: []nostr.Kind{nostr.KindProfileMetadata, nostr.KindFollowList}, Authors: []nostr.PubKey{sk.Public()}}, 0) {
+		found = append(found, evt)
+	}
+	if len(found) != 2 {
+		t.Fatalf("expected: 2 events, actual: %v", found)
+	}
+	for _, evt := range found {
+		if evt.ID != profile.ID && evt.ID != events[2].ID || !evt.VerifySignature() {
+			t.Fatalf("unexpected event: %v", evt)
+		}
+	}
+	// stopping early cancels the query in the worker
+	for range w.QueryEvents(nostr.Filter{Kinds: []nostr.Kind{nostr.KindProfileMetadata, nostr.KindFollowList}, Authors: []nostr.PubKey{sk.Public()}}, 0) {
+		break
+	}
+
+	if err := w.DeleteEvent(profile.ID); err != nil {
+		t.Fatal(err)
+	}
+	if db.IsExisted(db.ctx, profile.ID.Hex()) {
+		t.Fatal("expected the profile to be deleted")
+	}
+
+	w.Close()
+	if err := w.SaveEvent(profile); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected: %v, actual: %v", ErrClosed, err)
+	}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if err := w.SaveEvent(profile); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected the error of the backend, actual: %v", err)
+	}
+}
+
+func TestWorkerCredit(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipe := js.Global().Get("MessageChannel").New()
+	stop := ServePort(db.IndexeddbBackend, pipe.Get("port1"))
+	defer stop()
+	w := NewWorkerStore(pipe.Get("port2"))
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// more events than the credit of a single window
+	sk := nostr.Generate()
+	n := queryWindow*2 + 1
+	for i := range n {
+		evt := nostr.Event{Kind: nostr.KindCategorizedPeopleList, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"d", strconv.Itoa(i)}}}
+		if err := evt.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveEvent(evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := 0
+	for range w.QueryEvents(nostr.Filter{Kinds: []nostr.Kind{nostr.KindCategorizedPeopleList}, Authors: []nostr.PubKey{sk.Public()}}, 0) {
+		count++
+	}
+	if count != n {
+		t.Fatalf("expected: %d events, actual: %d", n, count)
+	}
+}
+
+func TestWorkerEarlyCancel(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.saveSigned(nostr.Generate(), nostr.KindFollowList); err != nil {
+		t.Fatal(err)
+	}
+	pipe := js.Global().Get("MessageChannel").New()
+	stop := ServePort(db.IndexeddbBackend, pipe.Get("port1"))
+	defer stop()
+
+	responses := make(chan rpcResponse, 8)
+	onMessage := js.FuncOf(func(this js.Value, args []js.Value) any {
+		var res rpcResponse
+		if err := json.Unmarshal([]byte(args[0].Get("data").String()), &res); err == nil {
+			responses <- res
+		}
+		return nil
+	})
+	defer onMessage.Release()
+	port := pipe.Get("port2")
+	port.Call("addEventListener", "message", onMessage)
+	port.Call("start")
+
+	// the cancel comes before the query even started in the worker
+	port.Call("postMessage", `{"id":1,"method":"query","filter":{"kinds":[3]}}`)
+	port.Call("postMessage", `{"id":1,"method":"cancel"}`)
+	select {
+	case res := <-responses:
+		if !res.Done || res.Event != nil {
+			t.Fatalf("expected the canceled query to be done without events, actual: %+v", res)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the query")
+	}
+}